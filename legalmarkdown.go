@@ -6,6 +6,7 @@
 package main
 
 import (
+	"fmt"
 	"github.com/codegangsta/cli"
 	"github.com/eris-ltd/legalmarkdown/lmd"
 	"log"
@@ -42,6 +43,14 @@ func main() {
 					Name:  "o, output",
 					Usage: "output file to be written",
 				},
+				cli.StringFlag{
+					Name:  "l, lib",
+					Usage: "template library directories to search for partials",
+				},
+				cli.BoolFlag{
+					Name:  "u, unused",
+					Usage: "mark the parameters of the front matter which the template no longer uses",
+				},
 			},
 			Action: cliMakeYAMLFrontMatter,
 		},
@@ -63,6 +72,26 @@ func main() {
 					Name:  "o, output",
 					Usage: "output file to be written",
 				},
+				cli.StringFlag{
+					Name:  "l, lib",
+					Usage: "template library directories to search for partials",
+				},
+				cli.StringFlag{
+					Name:  "x, crossref-check",
+					Usage: "report cross reference problems as warning, error, or off",
+				},
+				cli.StringFlag{
+					Name:  "d, term-check",
+					Usage: "report defined term problems as warning, error, or off",
+				},
+				cli.StringFlag{
+					Name:  "n, notes",
+					Usage: "write notes as footnotes or endnotes",
+				},
+				cli.BoolFlag{
+					Name:  "s, strict",
+					Usage: "do not write any output if errors are found",
+				},
 			},
 			Action: cliLegalToMarkdown,
 		},
@@ -84,9 +113,87 @@ func main() {
 					Name:  "o, output",
 					Usage: "output file to be written",
 				},
+				cli.StringFlag{
+					Name:  "l, lib",
+					Usage: "template library directories to search for partials",
+				},
+				cli.StringFlag{
+					Name:  "x, crossref-check",
+					Usage: "report cross reference problems as warning, error, or off",
+				},
+				cli.StringFlag{
+					Name:  "d, term-check",
+					Usage: "report defined term problems as warning, error, or off",
+				},
+				cli.StringFlag{
+					Name:  "n, notes",
+					Usage: "write notes as footnotes or endnotes",
+				},
+				cli.BoolFlag{
+					Name:  "s, strict",
+					Usage: "do not write any output if errors are found",
+				},
 			},
 			Action: cliMarkdownToPDF,
 		},
+
+		{
+			Name:      "lint",
+			ShortName: "l",
+			Usage:     "check a template for problems",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "t, template",
+					Usage: "template file to be parsed",
+				},
+				cli.StringFlag{
+					Name:  "p, parameters",
+					Usage: "parameters file to be parsed",
+				},
+				cli.StringFlag{
+					Name:  "l, lib",
+					Usage: "template library directories to search for partials",
+				},
+				cli.StringFlag{
+					Name:  "x, crossref-check",
+					Usage: "report cross reference problems as warning, error, or off",
+				},
+				cli.StringFlag{
+					Name:  "d, term-check",
+					Usage: "report defined term problems as warning, error, or off",
+				},
+			},
+			Action: cliLint,
+		},
+
+		{
+			Name:      "fill",
+			ShortName: "f",
+			Usage:     "answer the parameters of a template on the terminal",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "t, template",
+					Usage: "template file to be filled in",
+				},
+				cli.StringFlag{
+					Name:  "p, parameters",
+					Usage: "parameters file whose values are offered as the defaults",
+				},
+				cli.StringFlag{
+					Name:  "o, output",
+					Usage: "parameters file (or, with --render, parsed document) to write to",
+				},
+				cli.StringFlag{
+					Name:  "l, lib",
+					Usage: "template library directories to search for partials",
+				},
+				cli.BoolFlag{
+					Name:  "r, render",
+					Usage: "parse the template with the answers rather than writing a parameters file",
+				},
+			},
+			Action: cliFill,
+		},
 	}
 
 	legalmd.Run(os.Args)
@@ -101,6 +208,7 @@ func cliMakeYAMLFrontMatter(c *cli.Context) {
 	contents := c.String("template")
 	parameters := c.String("parameters")
 	output := c.String("output")
+	cliConfigure(c)
 
 	lmd.MakeYAMLFrontMatter(contents, parameters, output)
 }
@@ -118,6 +226,7 @@ func cliLegalToMarkdown(c *cli.Context) {
 	contents := c.String("template")
 	parameters := c.String("parameters")
 	output := c.String("output")
+	cliConfigure(c)
 
 	lmd.LegalToMarkdown(contents, parameters, output)
 }
@@ -135,6 +244,65 @@ func cliMarkdownToPDF(c *cli.Context) {
 	contents := c.String("template")
 	parameters := c.String("parameters")
 	output := c.String("output")
+	cliConfigure(c)
 
 	lmd.MarkdownToPDF(contents, parameters, output)
 }
+
+func cliLint(c *cli.Context) {
+
+	if c.String("template") == "" {
+		log.Fatal("Please specify a template file to parse with the --template or -t flag.")
+	}
+
+	contents := c.String("template")
+	parameters := c.String("parameters")
+	cliConfigure(c)
+
+	failed := false
+	for _, diagnostic := range lmd.Lint(contents, parameters) {
+		fmt.Println(diagnostic)
+		if diagnostic.Severity == "error" {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func cliFill(c *cli.Context) {
+
+	if c.String("template") == "" {
+		log.Fatal("Please specify a template file to fill in with the --template or -t flag.")
+	}
+
+	if c.String("output") == "" {
+		log.Fatal("Please specify an output file to write to with the --output or -o flag.")
+	}
+
+	contents := c.String("template")
+	parameters := c.String("parameters")
+	output := c.String("output")
+	cliConfigure(c)
+
+	lmd.Fill(contents, parameters, output, c.Bool("render"))
+}
+
+// cliConfigure sets the package level options of the lmd package from the flags which are
+// shared by the commands.
+func cliConfigure(c *cli.Context) {
+	lmd.LibraryPaths = append(lmd.LibraryPaths, lmd.SplitLibraryPath(c.String("lib"))...)
+	if c.String("crossref-check") != "" {
+		lmd.CrossReferenceChecks = c.String("crossref-check")
+	}
+	if c.String("term-check") != "" {
+		lmd.DefinedTermChecks = c.String("term-check")
+	}
+	if c.String("notes") != "" {
+		lmd.Footnotes = c.String("notes")
+	}
+	lmd.Strict = c.Bool("strict")
+	lmd.MarkUnusedParameters = c.Bool("unused")
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -153,6 +154,41 @@ func testIndividualFileJSON(file string) bool {
 
 }
 
+func TestLegalToMarkdownWithLibrary(t *testing.T) {
+	fmt.Println(CLR_B, "\n\tTesting Template Library Partials\n", CLR_N)
+
+	// point the template library at the fixtures
+	lmd.LibraryPaths = []string{"std=" + filepath.Join(".", "spec", "library", "std"), filepath.Join(".", "spec", "library", "shared")}
+	defer func() { lmd.LibraryPaths = nil }()
+
+	// create the path properly to the glob command
+	testFilesPath := filepath.Join(".", "spec", "library", "*.lmd")
+
+	// glob the files
+	testfiles, readError := filepath.Glob(testFilesPath)
+	if readError != nil {
+		t.Error(readError)
+	}
+
+	// set up passed and failed slices
+	passed := []string{}
+	failed := []string{}
+
+	// run the unit tests
+	for _, file := range testfiles {
+		successOrFail := testIndividualFileYAML(file)
+		if successOrFail {
+			passed = append(passed, file)
+		} else {
+			failed = append(failed, file)
+			t.Error("Fast fail.")
+		}
+	}
+
+	reportResults(passed, failed)
+
+}
+
 func TestLegalToMarkdownHeaders(t *testing.T) {
 	fmt.Println(CLR_B, "\n\tTesting Make YAML Front Matter\n", CLR_N)
 
@@ -220,6 +256,82 @@ func testIndividualFileHeaders(file string) bool {
 
 }
 
+func TestLegalToMarkdownHeadersMarkingUnused(t *testing.T) {
+	fmt.Println(CLR_B, "\n\tTesting Headers Marking Unused Parameters\n", CLR_N)
+
+	// create the path properly to the glob command
+	testFilesPath := filepath.Join(".", "spec", "unused", "*.lmd")
+
+	// glob the files
+	testfiles, readError := filepath.Glob(testFilesPath)
+	if readError != nil {
+		t.Error(readError)
+	}
+
+	// set up passed and failed slices
+	passed := []string{}
+	failed := []string{}
+
+	// run the unit tests with the unused parameters marked
+	lmd.MarkUnusedParameters = true
+	defer func() { lmd.MarkUnusedParameters = false }()
+	for _, file := range testfiles {
+		successOrFail := testIndividualFileHeaders(file)
+		if successOrFail {
+			passed = append(passed, file)
+		} else {
+			failed = append(failed, file)
+			t.Error("Fast fail.")
+		}
+	}
+
+	reportResults(passed, failed)
+
+}
+
+// TestIndentFormat parses a template with an indent format which places each provision in an html
+// division indented in ems, and checks the conversion of an indentation into ems and twips.
+func TestIndentFormat(t *testing.T) {
+	fmt.Println(CLR_B, "\n\tTesting Indent Format\n", CLR_N)
+
+	// create the path properly to the glob command
+	testFilesPath := filepath.Join(".", "spec", "indent", "*.lmd")
+
+	// glob the files
+	testfiles, readError := filepath.Glob(testFilesPath)
+	if readError != nil {
+		t.Error(readError)
+	}
+
+	// set up passed and failed slices
+	passed := []string{}
+	failed := []string{}
+
+	// run the unit tests with each provision indented in html
+	lmd.IndentFormat = func(provision string, indentation lmd.Indentation) string {
+		return fmt.Sprintf("<div style=\"margin-left: %vem\">%v</div>", indentation.Em(), provision)
+	}
+	defer func() { lmd.IndentFormat = nil }()
+	for _, file := range testfiles {
+		successOrFail := testIndividualFileYAML(file)
+		if successOrFail {
+			passed = append(passed, file)
+		} else {
+			failed = append(failed, file)
+			t.Error("Fast fail.")
+		}
+	}
+
+	reportResults(passed, failed)
+
+	if twips := (lmd.Indentation{Characters: 4}).Twips(); twips != 480 {
+		t.Errorf("Expected 4 characters to be 480 twips, got %v", twips)
+	}
+	if em := (lmd.Indentation{Tabs: 1}).Em(); em != 2 {
+		t.Errorf("Expected a tab to be 2 ems, got %v", em)
+	}
+}
+
 func TestGetParameters(t *testing.T) {
 	fmt.Println(CLR_B, "\n\tTesting Get Parameters\n", CLR_N)
 
@@ -266,6 +378,263 @@ func TestGetParameters(t *testing.T) {
 	} else {
 		fmt.Println(CLR_G, "JSONizing parameters #2 -- made from a raw LMD -- passed.\n", CLR_N)
 	}
+
+	testFile3 := filepath.Join(".", "spec", "59.parameters_with_schema.lmd")
+	test3 := lmd.GetTheParameters(testFile3)
+	basis = `{"fee":"one hundred","governing_law":"England","party":"Acme Ltd","term":"12"}`
+
+	if test3 != basis {
+		fmt.Println(CLR_R, "NOOOOOOOOOOOOOOOOO.\n", CLR_N)
+		fmt.Println(CLR_G, "Expected =>", CLR_N)
+		fmt.Println(basis)
+		fmt.Println(CLR_R, "Result =>", CLR_N)
+		fmt.Println(test3)
+		t.Error("JSONizing parameters #3 -- without the schema -- failed.\n")
+	} else {
+		fmt.Println(CLR_G, "JSONizing parameters #3 -- without the schema -- passed.\n", CLR_N)
+	}
+}
+
+func TestGetParameterDependencies(t *testing.T) {
+	fmt.Println(CLR_B, "\n\tTesting Get Parameter Dependencies\n", CLR_N)
+
+	testFile := filepath.Join(".", "spec", "dependencies", "00.nested_clauses.lmd")
+	basis := strings.TrimSpace(lmd.ReadAFile(strings.Replace(testFile, ".lmd", ".json", 1)))
+
+	dependencies := lmd.GetTheParameterDependencies(testFile)
+	if dependencies != basis {
+		fmt.Println(CLR_R, "NOOOOOOOOOOOOOOOOO.\n", CLR_N)
+		fmt.Println(CLR_G, "Expected =>", CLR_N)
+		fmt.Println(basis)
+		fmt.Println(CLR_R, "Result =>", CLR_N)
+		fmt.Println(dependencies)
+		t.Error("JSONizing the parameter dependencies failed.\n")
+	} else {
+		fmt.Println(CLR_G, "JSONizing the parameter dependencies passed.\n", CLR_N)
+	}
+
+	// the parameters of the same template are still a flat object of strings, with the tree
+	// under the reserved key.
+	param := make(map[string]string)
+	if err := json.Unmarshal([]byte(lmd.GetTheParameters(testFile)), &param); err != nil {
+		t.Errorf("The parameters of a template with dependencies are not a flat object of strings: %v", err)
+	}
+	if param["@dependencies"] != basis {
+		t.Errorf("The parameters do not include the dependency tree: expected %v, got %v", basis, param["@dependencies"])
+	}
+}
+
+func TestFill(t *testing.T) {
+	fmt.Println(CLR_B, "\n\tTesting Filling In Templates\n", CLR_N)
+
+	// create the path properly to the glob command
+	testFilesPath := filepath.Join(".", "spec", "fill", "*.lmd")
+
+	// glob the files
+	testfiles, readError := filepath.Glob(testFilesPath)
+	if readError != nil {
+		t.Error(readError)
+	}
+
+	// the questions are not wanted in the output of the tests
+	lmd.FillPrompts = ioutil.Discard
+	defer func() { lmd.FillInput, lmd.FillPrompts = os.Stdin, os.Stderr }()
+
+	// set up passed and failed slices
+	passed := []string{}
+	failed := []string{}
+
+	// run the unit tests
+	for _, file := range testfiles {
+		successOrFail := testIndividualFileFill(file)
+		if successOrFail {
+			passed = append(passed, file)
+		} else {
+			failed = append(failed, file)
+			t.Error("Fast fail.")
+		}
+	}
+
+	reportResults(passed, failed)
+
+}
+
+func testIndividualFileFill(file string) bool {
+	// announce thyself
+	fmt.Println(CLR_0, "Testing file: ", file, CLR_N)
+
+	// set the basis and read it into memory
+	basisFile := strings.Replace(file, ".lmd", ".yml", 1)
+	testAgainstMe := lmd.ReadAFile(basisFile)
+
+	// answer the questions from the answers file
+	answers, answersErr := os.Open(strings.Replace(file, ".lmd", ".answers", 1))
+	if answersErr != nil {
+		log.Fatal(answersErr)
+	}
+	defer answers.Close()
+	lmd.FillInput = answers
+
+	// make a temp file
+	tempFile, tempFileErr := ioutil.TempFile(os.TempDir(), "lmd-test-")
+	if tempFileErr != nil {
+		log.Fatal(tempFileErr)
+	}
+	defer os.Remove(tempFile.Name())
+
+	// run Fill on the fixture
+	lmd.Fill(file, "", tempFile.Name(), false)
+
+	// read the tempfile
+	iMadeThisFile := lmd.ReadAFile(tempFile.Name())
+
+	// announce
+	if testAgainstMe == iMadeThisFile {
+		fmt.Println(CLR_G, "YES!\n", CLR_N)
+		return true
+	} else {
+		fmt.Println(CLR_R, "NOOOOOOOOOOOOOOOOO.\n", CLR_N)
+		fmt.Println(CLR_G, "Expected =>", CLR_N)
+		fmt.Println(testAgainstMe)
+		fmt.Println(CLR_R, "Result =>", CLR_N)
+		fmt.Println(iMadeThisFile)
+		return false
+	}
+
+}
+
+func TestDiagnostics(t *testing.T) {
+	fmt.Println(CLR_B, "\n\tTesting Diagnostics\n", CLR_N)
+
+	// create the path properly to the glob command
+	testFilesPath := filepath.Join(".", "spec", "*.diagnostics")
+
+	// glob the files
+	testfiles, readError := filepath.Glob(testFilesPath)
+	if readError != nil {
+		t.Error(readError)
+	}
+
+	// set up passed and failed slices
+	passed := []string{}
+	failed := []string{}
+
+	// run the unit tests
+	for _, file := range testfiles {
+		successOrFail := testIndividualFileDiagnostics(file)
+		if successOrFail {
+			passed = append(passed, file)
+		} else {
+			failed = append(failed, file)
+			t.Error("Fast fail.")
+		}
+	}
+
+	reportResults(passed, failed)
+
+}
+
+func testIndividualFileDiagnostics(file string) bool {
+	// announce thyself
+	fmt.Println(CLR_0, "Testing file: ", file, CLR_N)
+
+	// set the basis and read it into memory
+	testAgainstMe := lmd.ReadAFile(file)
+
+	// lint the template and write out its diagnostics one to a line
+	iMadeThis := ""
+	for _, diagnostic := range lmd.Lint(strings.Replace(file, ".diagnostics", ".lmd", 1), "") {
+		iMadeThis = iMadeThis + diagnostic.String() + "\n"
+	}
+
+	// announce
+	if testAgainstMe == iMadeThis {
+		fmt.Println(CLR_G, "YES!\n", CLR_N)
+		return true
+	} else {
+		fmt.Println(CLR_R, "NOOOOOOOOOOOOOOOOO.\n", CLR_N)
+		fmt.Println(CLR_G, "Expected =>", CLR_N)
+		fmt.Println(testAgainstMe)
+		fmt.Println(CLR_R, "Result =>", CLR_N)
+		fmt.Println(iMadeThis)
+		return false
+	}
+
+}
+
+// TestDefinedTermChecks lints a template with problems with its defined terms with the checks set
+// to report them as errors and then turned off.
+func TestDefinedTermChecks(t *testing.T) {
+	fmt.Println(CLR_B, "\n\tTesting Defined Term Checks\n", CLR_N)
+
+	testFile := filepath.Join(".", "spec", "46.block_with_defined_terms.lmd")
+	defer func() { lmd.DefinedTermChecks = "warning" }()
+
+	lmd.DefinedTermChecks = "error"
+	diagnostics := lmd.Lint(testFile, "")
+	if len(diagnostics) != 4 {
+		t.Errorf("Expected 4 diagnostics with the defined term checks set to error, got %v", diagnostics)
+	}
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity != "error" {
+			t.Errorf("Expected an error, got %v", diagnostic)
+		}
+	}
+
+	lmd.DefinedTermChecks = "off"
+	if diagnostics := lmd.Lint(testFile, ""); len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics with the defined term checks off, got %v", diagnostics)
+	}
+}
+
+// TestStrict runs templates with errors in strict mode: one with bad cross references, which are
+// reported as errors, and one whose parameters do not match its schema. Strict mode stops
+// legalmarkdown with log.Fatal, so each parse is run in a copy of the test binary and the test
+// checks that it failed without writing any output.
+func TestStrict(t *testing.T) {
+
+	if output := os.Getenv("LMD_STRICT_OUTPUT"); output != "" {
+		lmd.Strict = true
+		lmd.CrossReferenceChecks = "error"
+		lmd.LegalToMarkdown(os.Getenv("LMD_STRICT_TEMPLATE"), "", output)
+		return
+	}
+
+	fmt.Println(CLR_B, "\n\tTesting Strict Mode\n", CLR_N)
+
+	tempDir, tempDirErr := ioutil.TempDir(os.TempDir(), "lmd-test-")
+	if tempDirErr != nil {
+		t.Fatal(tempDirErr)
+	}
+	defer os.RemoveAll(tempDir)
+
+	crossrefFile := filepath.Join(".", "spec", "44.block_with_bad_crossrefs.lmd")
+	schemaFile := filepath.Join(".", "spec", "59.parameters_with_schema.lmd")
+	tests := map[string][]string{
+		crossrefFile: {crossrefFile + ":10: error: cross reference |delivery| could not be resolved; it is never staked"},
+		schemaFile:   {schemaFile + `:5: error: parameter "fee" is "one hundred" which is not a number`},
+	}
+
+	for testFile, expectations := range tests {
+		fmt.Println(CLR_0, "Testing file: ", testFile, CLR_N)
+		output := filepath.Join(tempDir, filepath.Base(testFile)+".md")
+
+		run := exec.Command(os.Args[0], "-test.run=TestStrict")
+		run.Env = append(os.Environ(), "LMD_STRICT_OUTPUT="+output, "LMD_STRICT_TEMPLATE="+testFile)
+		stderr, runErr := run.CombinedOutput()
+
+		if runErr == nil {
+			t.Errorf("Strict mode did not stop the parse of %v.", testFile)
+		}
+		if _, statErr := os.Stat(output); statErr == nil {
+			t.Errorf("Strict mode wrote the output of %v even though errors were found.", testFile)
+		}
+		for _, expected := range append(expectations, "Stopping because errors were found in strict mode.") {
+			if !strings.Contains(string(stderr), expected) {
+				t.Errorf("Strict mode did not report %q; it reported:\n%v", expected, string(stderr))
+			}
+		}
+	}
 }
 
 func TestLegalToRenderingToPDF(t *testing.T) {
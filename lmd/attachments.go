@@ -0,0 +1,130 @@
+package lmd
+
+import (
+	"regexp"
+)
+
+// attachment is a schedule, exhibit or annex which follows the body of the document. Kind is the
+// kind of attachment, key is the name by which it is cross referenced, title is its title (which
+// may be empty), marks are any markdown heading marks written before the directive, number is its
+// number among the attachments of its kind and text is everything from its directive to the next
+// attachment or the end of the document.
+type attachment struct {
+	kind   string
+	key    string
+	title  string
+	marks  string
+	number string
+	text   string
+}
+
+// attachmentKind describes how a kind of attachment is named and numbered. Name is the word which
+// begins its heading, reference is the prefix of its cross reference keys and first is the number
+// of the first attachment of the kind.
+type attachmentKind struct {
+	name      string
+	reference string
+	first     string
+}
+
+// attachmentKinds are the kinds of attachment which may be started with a directive. Schedules and
+// annexes are numbered and exhibits are lettered.
+var attachmentKinds = map[string]attachmentKind{
+	"schedule": {"Schedule", "sched", "1"},
+	"exhibit":  {"Exhibit", "exhibit", "A"},
+	"annex":    {"Annex", "annex", "1"},
+}
+
+// attachmentPattern matches the directives which start an attachment, as in `@schedule disclosure
+// Disclosure Letter`, with any markdown heading marks which come before the directive.
+var attachmentPattern = regexp.MustCompile(`(?m)^(#+[ \t]+)?@(schedule|exhibit|annex)[ \t]+(\S+)(?:[ \t]+(.+?))?[ \t]*$`)
+
+// splitTheAttachments separates the body of the document from the attachments which follow it and
+// numbers each attachment among those of its kind.
+func splitTheAttachments(contents string) (string, []*attachment) {
+
+	matches := attachmentPattern.FindAllStringSubmatchIndex(contents, -1)
+	if len(matches) == 0 {
+		return contents, nil
+	}
+
+	attachments := []*attachment{}
+	numbers := make(map[string]string)
+	for i, match := range matches {
+		end := len(contents)
+		if i < len(matches)-1 {
+			end = matches[i+1][0]
+		}
+		kind := contents[match[4]:match[5]]
+		if numbers[kind] == "" {
+			numbers[kind] = attachmentKinds[kind].first
+		} else if kind == "exhibit" {
+			numbers[kind] = next_lettering(numbers[kind])
+		} else {
+			numbers[kind] = next_numbering(numbers[kind])
+		}
+		part := &attachment{kind: kind, key: contents[match[6]:match[7]], number: numbers[kind], text: contents[match[1]:end]}
+		if match[2] >= 0 {
+			part.marks = contents[match[2]:match[3]]
+		}
+		if match[8] >= 0 {
+			part.title = contents[match[8]:match[9]]
+		}
+		attachments = append(attachments, part)
+	}
+
+	return contents[:matches[0][0]], attachments
+}
+
+// leader returns the name and number of the attachment, as in "Schedule 1".
+func (part *attachment) leader() string {
+	return attachmentKinds[part.kind].name + " " + part.number
+}
+
+// heading returns the heading of the attachment which takes the place of its directive, as in
+// "Schedule 1 – Disclosure Letter", with any heading marks which came before the directive.
+func (part *attachment) heading() string {
+	if part.title == "" {
+		return part.marks + part.leader()
+	}
+	return part.marks + part.leader() + " – " + part.title
+}
+
+// crossReference returns the key by which the attachment is cross referenced, as in
+// "sched:disclosure", and the cross reference it resolves to. The leader of the attachment is
+// used for |sched:disclosure| and its title for |sched:disclosure:title|.
+func (part *attachment) crossReference() (string, *crossReference) {
+	return attachmentKinds[part.kind].reference + ":" + part.key, &crossReference{leader: part.leader(), title: part.title}
+}
+
+// tocEntry returns the entry which lists the attachment in the table of contents.
+func (part *attachment) tocEntry() tocEntry {
+	entry := tocEntry{1, part.leader(), "", tocSlug(part.leader()), -1}
+	if part.title != "" {
+		entry.heading = "– " + part.title
+	}
+	return entry
+}
+
+// provisionName names a provision of an attachment for the defined terms, as in "Schedule 1, 2"
+// for a provision or "Schedule 1" for the text outside of its provisions.
+func (part *attachment) provisionName(provision string) string {
+	if provision == "" {
+		return part.leader()
+	}
+	return part.leader() + ", " + provision
+}
+
+// resetTheHeaders returns every header to its first value so that an attachment is numbered
+// independently of the provisions which come before it.
+func resetTheHeaders(headers map[string]*Header) {
+	for _, header := range headers {
+		header.currtVal = header.resetVal
+	}
+}
+
+// anchorTheAttachment places an html anchor for the table of contents to link to after the heading
+// of the attachment.
+func anchorTheAttachment(heading string, part *attachment) string {
+	return heading + " <a id=\"" + part.tocEntry().anchor + "\"></a>"
+}
@@ -0,0 +1,262 @@
+package lmd
+
+import (
+	"regexp"
+	"strings"
+)
+
+// crossReference holds what is known about a provision which has been staked with a cross
+// reference. Leader is the full leader of the provision as it appears in the document (less
+// any trailing period) and title is the heading of the provision. Attachment is the leader of the
+// schedule, exhibit or annex which holds the provision, if it is not in the body of the document.
+// Duplicate is set once the key has been reported as staked more than once.
+type crossReference struct {
+	leader     string
+	title      string
+	attachment string
+	duplicate  bool
+}
+
+// crossReferenceSource records where a cross reference key was staked or used in the files
+// which make up a template, so that problems with the cross references can be reported against
+// the lines on which they occur.
+type crossReferenceSource struct {
+	file  string
+	line  int
+	key   string
+	stake bool
+}
+
+// CrossReferenceChecks sets how problems with cross references are reported: "warning" (the
+// default) and "error" report references which are never staked and keys which are staked more
+// than once with that severity, while "off" does not check the cross references at all.
+var CrossReferenceChecks = "warning"
+
+// crossReferenceSources holds the stakes and uses found in the files read for the current parse
+// job. It is emptied by resetTheDiagnostics along with the diagnostics.
+var crossReferenceSources []crossReferenceSource
+
+// crossReferenceStake and crossReferenceUse match the stakes at the beginning of a provision and
+// the uses of cross references (in any of their forms) within the text.
+var crossReferenceStake = regexp.MustCompile(`\A(?:l+|l[0-9]+)\.(?:[=+]\S+)? \|(.+?)\|`)
+var crossReferenceUse = regexp.MustCompile(`\|([^|\s]+?)(?::(?:num|full|title|page))?\|`)
+
+// codeSpanPattern matches the inline code spans of a line, and wordBefore and wordAfter match a
+// letter or digit on either side of a cross reference; see findTheCrossReferenceUses.
+var codeSpanPattern = regexp.MustCompile("`[^`]*`")
+var wordBefore = regexp.MustCompile(`[\pL\pN_]\z`)
+var wordAfter = regexp.MustCompile(`\A[\pL\pN_]`)
+
+// PageReference is called to fill in |key:page| cross references. Legalmarkdown does not know
+// how the document will be paginated, so renderers which do may set this function; it receives
+// the cross reference key and the full leader of the staked provision and returns the text to put
+// in the document. If it is not set, |key:page| references are filled in with the full leader.
+var PageReference func(key string, leader string) string
+
+// crossReferenceVariants are the forms in which a cross reference may be used in the text. A bare
+// |key| is the same as |key:full|.
+var crossReferenceVariants = []string{"num", "full", "title", "page"}
+
+// replaceTheCrossReferences replaces the cross references throughout the contents which
+// are passed to it. Each cross reference may be used in any of these forms:
+//
+//	|key|        the full leader of the provision, such as "Section 7(a)"
+//	|key:full|   the same as |key|
+//	|key:num|    only the number of the provision, such as "7(a)"
+//	|key:title|  the heading of the provision
+//	|key:page|   the page of the provision, as filled in by PageReference
+//
+// The full leader of a provision in an attachment names the attachment, as in "Section 2 of
+// Schedule 1", and within an attachment the full leader of a provision of the body names the body,
+// as in "Section 2 of this Agreement"; see HandleTheHeaders.
+func replaceTheCrossReferences(contents string, crossref map[string]*crossReference) string {
+	for pointer, reference := range crossref {
+		for _, variant := range crossReferenceVariants {
+			contents = strings.Replace(contents, "|"+pointer+":"+variant+"|", reference.variant(pointer, variant), -1)
+		}
+		contents = strings.Replace(contents, "|"+pointer+"|", reference.variant(pointer, "full"), -1)
+	}
+	return contents
+}
+
+// BodyReference names the body of the document when a provision of the body is cross referenced
+// from within a schedule, exhibit or annex, as in "Section 2 of this Agreement", so that it is not
+// mistaken for a provision of the attachment itself.
+var BodyReference = "this Agreement"
+
+// referencesFromAnAttachment returns copies of the cross references staked in the body of the
+// document which name the body (see BodyReference), for replacing the references to them from
+// within the attachments.
+func referencesFromAnAttachment(crossref map[string]*crossReference) map[string]*crossReference {
+	qualified := make(map[string]*crossReference)
+	for pointer, reference := range crossref {
+		qualified[pointer] = &crossReference{leader: reference.leader, title: reference.title, attachment: BodyReference}
+	}
+	return qualified
+}
+
+// variant returns the text which a cross reference should be replaced with for the given form.
+func (reference *crossReference) variant(pointer string, variant string) string {
+	switch variant {
+	case "num":
+		return provisionNumber(reference.leader)
+	case "title":
+		return reference.title
+	case "page":
+		if PageReference != nil {
+			return PageReference(pointer, reference.full())
+		}
+		return reference.full()
+	default:
+		return reference.full()
+	}
+}
+
+// full returns the full leader of the provision, with the attachment which holds it if there is one.
+func (reference *crossReference) full() string {
+	if reference.attachment == "" {
+		return reference.leader
+	}
+	return reference.leader + " of " + reference.attachment
+}
+
+// provisionNumber strips the words which come before the number in a leader, so that
+// "Section 102(a)" becomes "102(a)". The number itself never contains a space.
+func provisionNumber(leader string) string {
+	leader = strings.TrimSpace(leader)
+	if i := strings.LastIndex(leader, " "); i >= 0 {
+		return leader[i+1:]
+	}
+	return leader
+}
+
+// provisionHeading pulls the heading out of the text of a provision. A heading which is written in
+// braces (see formatTheHeading) is taken as it is, less any period, colon or semicolon which
+// closes it. Otherwise the heading is the first line of the provision up to its first period, with
+// the trailing "*" which some templates use to mark headings removed. The references to any
+// footnotes are left out.
+func provisionHeading(text string) string {
+	text = footnoteMarkerPattern.ReplaceAllString(text, "")
+	if headingPattern.MatchString(text) {
+		return strings.TrimRight(strings.TrimSpace(headingPattern.FindStringSubmatch(text)[2]), ".:;")
+	}
+	text = strings.TrimSpace(text)
+	if i := strings.Index(text, "\n"); i >= 0 {
+		text = text[:i]
+	}
+	headingPattern := regexp.MustCompile(`\A(.*?)\.(\s|\z)`)
+	if headingPattern.MatchString(text) {
+		text = headingPattern.FindStringSubmatch(text)[1]
+	}
+	return strings.TrimSuffix(strings.TrimSpace(text), "*")
+}
+
+// scanTheCrossReferences records the line of every stake and use of a cross reference in the
+// contents of a file. The firstLine is the number of lines of the file which come before the
+// contents, such as those taken up by front matter which has already been removed.
+func scanTheCrossReferences(contents string, file string, firstLine int) {
+	for i, line := range strings.Split(contents, "\n") {
+		if crossReferenceStake.MatchString(line) {
+			stake := crossReferenceStake.FindStringSubmatch(line)
+			crossReferenceSources = append(crossReferenceSources, crossReferenceSource{file, firstLine + i + 1, stake[1], true})
+			line = line[len(stake[0]):]
+		}
+		if isATableRow(line) {
+			continue
+		}
+		for _, use := range findTheCrossReferenceUses(line) {
+			crossReferenceSources = append(crossReferenceSources, crossReferenceSource{file, firstLine + i + 1, use[1], false})
+		}
+	}
+}
+
+// checkTheDuplicateStake is called when a cross reference key is staked a second time. It
+// reports every line on which the key is staked, once per key.
+func checkTheDuplicateStake(pointer string, existing *crossReference) {
+	if CrossReferenceChecks == "off" || existing.duplicate {
+		return
+	}
+	existing.duplicate = true
+	found := false
+	for _, source := range crossReferenceSources {
+		if source.stake && source.key == pointer {
+			addDiagnostic(crossReferenceSeverity(), source.file, source.line, "cross reference |%v| is staked more than once", pointer)
+			found = true
+		}
+	}
+	if !found {
+		addDiagnostic(crossReferenceSeverity(), "", 0, "cross reference |%v| is staked more than once", pointer)
+	}
+}
+
+// checkTheDanglingReferences looks through the contents after the cross references have been
+// replaced for any which remain, and reports every line on which such a reference is used.
+// Markdown table rows, inline code and pipes between words are skipped as the pipes in them are
+// not cross references.
+func checkTheDanglingReferences(contents string) {
+	if CrossReferenceChecks == "off" {
+		return
+	}
+	dangling := []string{}
+	reported := make(map[string]bool)
+	for _, line := range strings.Split(contents, "\n") {
+		if isATableRow(line) {
+			continue
+		}
+		for _, use := range findTheCrossReferenceUses(line) {
+			if !reported[use[1]] {
+				reported[use[1]] = true
+				dangling = append(dangling, use[1])
+			}
+		}
+	}
+	for _, pointer := range dangling {
+		reason := "it is never staked"
+		for _, source := range crossReferenceSources {
+			if source.stake && source.key == pointer {
+				reason = "the provision it is staked to was not given a structured header"
+			}
+		}
+		found := false
+		for _, source := range crossReferenceSources {
+			if !source.stake && source.key == pointer {
+				addDiagnostic(crossReferenceSeverity(), source.file, source.line, "cross reference |%v| could not be resolved; %v", pointer, reason)
+				found = true
+			}
+		}
+		if !found {
+			addDiagnostic(crossReferenceSeverity(), "", 0, "cross reference |%v| could not be resolved; %v", pointer, reason)
+		}
+	}
+}
+
+// crossReferenceSeverity returns the severity with which cross reference problems are reported.
+// Anything other than "error" is treated as "warning".
+func crossReferenceSeverity() string {
+	if CrossReferenceChecks == "error" {
+		return "error"
+	}
+	return "warning"
+}
+
+// findTheCrossReferenceUses returns the uses of cross references in a line, as the submatches of
+// crossReferenceUse. Pipes within inline code spans, and pipes which run on from a word on either
+// side (as in a|b|c), are ordinary text rather than cross references and are left out.
+func findTheCrossReferenceUses(line string) [][]string {
+	line = codeSpanPattern.ReplaceAllStringFunc(line, func(span string) string {
+		return strings.Repeat(" ", len(span))
+	})
+	uses := [][]string{}
+	for _, match := range crossReferenceUse.FindAllStringSubmatchIndex(line, -1) {
+		if wordBefore.MatchString(line[:match[0]]) || wordAfter.MatchString(line[match[1]:]) {
+			continue
+		}
+		uses = append(uses, []string{line[match[0]:match[1]], line[match[2]:match[3]]})
+	}
+	return uses
+}
+
+// isATableRow returns true if the line is a row of a markdown table.
+func isATableRow(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "|")
+}
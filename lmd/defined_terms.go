@@ -0,0 +1,277 @@
+package lmd
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// definedTerm is a term which the document defines, such as ("Company") or (the "Act"). Provision
+// is the final leader of the provision in which the term is defined, or an empty string if it is
+// defined outside of the structured headers block. Part and offset locate the definition within
+// the parts of the document so that uses can be placed before or after it.
+type definedTerm struct {
+	term      string
+	provision string
+	part      int
+	offset    int
+}
+
+// definedTermPart is a piece of the document which is checked for defined terms along with the
+// final leader of the provision it belongs to. The pieces are passed in the order in which they
+// appear in the document.
+type definedTermPart struct {
+	provision string
+	text      string
+}
+
+// definedTermSource is a line of one of the files read for the current parse job. The lines are
+// searched for the definitions and uses of the defined terms so that the diagnostics about them can
+// be filed against the line on which they were written.
+type definedTermSource struct {
+	file string
+	line int
+	text string
+}
+
+// definedTermSources holds the lines of the files read for the current parse job, in the order in
+// which the files were read. It is emptied by resetTheDiagnostics.
+var definedTermSources []definedTermSource
+
+// DefinedTermChecks sets how problems with defined terms are reported: "warning" (the default)
+// and "error" report terms which are defined but never used, used before they are defined, used in
+// the wrong case or defined more than once with that severity, while "off" does not check the
+// defined terms at all.
+var DefinedTermChecks = "warning"
+
+// definitionPattern matches the definition of a term in parentheses, with or without words before
+// the quoted term: ("Company"), (the "Act") and (hereinafter the "Seller") all define a term.
+var definitionPattern = regexp.MustCompile(`\((?:[^()"“”\n]*?\s)?["“]([^"”\n]+)["”]\)`)
+
+// definedTermsIndexPattern matches the `@defined-terms` lines which mark where an index of the
+// defined terms should be placed.
+var definedTermsIndexPattern = regexp.MustCompile(`(?m)^@defined-terms[ \t]*$`)
+
+// definedTermParts pairs the text before the block, each provision of the block and the text after
+// the block with the final leaders of the provisions, for handleTheDefinedTerms.
+func definedTermParts(preBlock string, blockAsSlice []string, postBlock string, entries []tocEntry) []definedTermPart {
+	leaders := make(map[int]string)
+	for _, entry := range entries {
+		leaders[entry.index] = strings.TrimSuffix(entry.leader, ".")
+	}
+	parts := []definedTermPart{{"", preBlock}}
+	for i, block := range blockAsSlice {
+		parts = append(parts, definedTermPart{leaders[i], block})
+	}
+	return append(parts, definedTermPart{"", postBlock})
+}
+
+// handleTheDefinedTerms collects the terms which are defined in the parts of the document, checks
+// how they are used and replaces any `@defined-terms` lines in the contents with an index of the
+// defined terms and the provisions in which they are defined.
+//
+// A defined term is reported if it is never used outside of its definition, if it is used before
+// the part of the document in which it is defined, or if it is used with different capitals than
+// those it was defined with (as "company" for "Company"). A term which is used within the
+// definition of another term, or earlier in the provision which defines it (as in `the consulting
+// services (the "Services")`), is not counted as a use.
+func handleTheDefinedTerms(contents string, parts []definedTermPart) string {
+
+	terms := []*definedTerm{}
+	defined := make(map[string]*definedTerm)
+	definitions := make(map[int][][]int)
+	definitionCount := make(map[string]int)
+
+	for i, part := range parts {
+		for _, match := range definitionPattern.FindAllStringSubmatchIndex(part.text, -1) {
+			definitions[i] = append(definitions[i], match[:2])
+			term := part.text[match[2]:match[3]]
+			definitionCount[term]++
+			if existing := defined[term]; existing != nil {
+				file, line := locateTheDefinition(term, definitionCount[term]-1)
+				addDefinedTermDiagnostic(file, line, "defined term %q is defined in %v and again in %v", term, describeTheProvision(existing.provision), describeTheProvision(part.provision))
+				continue
+			}
+			defined[term] = &definedTerm{term, part.provision, i, match[0]}
+			terms = append(terms, defined[term])
+		}
+	}
+
+	if DefinedTermChecks != "off" {
+		for _, term := range terms {
+			checkTheDefinedTerm(term, parts, definitions)
+		}
+	}
+
+	if !definedTermsIndexPattern.MatchString(contents) {
+		return contents
+	}
+	return definedTermsIndexPattern.ReplaceAllLiteralString(contents, buildTheDefinedTermsIndex(terms))
+}
+
+// checkTheDefinedTerm looks through the parts of the document for the uses of a single defined
+// term and reports any problems with them. Uses which fall within a definition are skipped.
+func checkTheDefinedTerm(term *definedTerm, parts []definedTermPart, definitions map[int][][]int) {
+
+	pattern := definedTermPattern(term.term)
+	used := false
+	usedBefore := ""
+	usedBeforeAt := 0
+	wrongCase := make(map[string]bool)
+	seen := make(map[string]int)
+
+	for i, part := range parts {
+	uses:
+		for _, use := range pattern.FindAllStringIndex(part.text, -1) {
+			for _, definition := range definitions[i] {
+				if use[0] >= definition[0] && use[1] <= definition[1] {
+					continue uses
+				}
+			}
+			text := part.text[use[0]:use[1]]
+			occurrence := seen[text]
+			seen[text]++
+			// the words which lead up to a definition usually describe the term, so only the
+			// text which follows the definition is checked within the part which defines it.
+			if i == term.part && use[0] < term.offset {
+				continue
+			}
+			if text != term.term {
+				key := text + "\x00" + part.provision
+				if !wrongCase[key] {
+					wrongCase[key] = true
+					file, line := locateTheUse(text, occurrence)
+					addDefinedTermDiagnostic(file, line, "defined term %q is used as %q in %v", term.term, text, describeTheProvision(part.provision))
+				}
+				continue
+			}
+			used = true
+			if usedBefore == "" && i < term.part {
+				usedBefore = describeTheProvision(part.provision)
+				usedBeforeAt = occurrence
+			}
+		}
+	}
+
+	if !used {
+		file, line := locateTheDefinition(term.term, 0)
+		addDefinedTermDiagnostic(file, line, "defined term %q is defined in %v but never used", term.term, describeTheProvision(term.provision))
+	}
+	if usedBefore != "" {
+		file, line := locateTheUse(term.term, usedBeforeAt)
+		addDefinedTermDiagnostic(file, line, "defined term %q is used in %v before it is defined in %v", term.term, usedBefore, describeTheProvision(term.provision))
+	}
+}
+
+// buildTheDefinedTermsIndex assembles the index of defined terms as a markdown list, sorted
+// alphabetically, with the provision in which each term is defined.
+func buildTheDefinedTermsIndex(terms []*definedTerm) string {
+	sorted := definedTermsByName(append([]*definedTerm{}, terms...))
+	sort.Stable(sorted)
+	lines := []string{}
+	for _, term := range sorted {
+		if term.provision == "" {
+			lines = append(lines, "- **"+term.term+"**")
+		} else {
+			lines = append(lines, "- **"+term.term+"**: "+term.provision)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// definedTermsByName sorts defined terms alphabetically without regard to case.
+type definedTermsByName []*definedTerm
+
+func (t definedTermsByName) Len() int      { return len(t) }
+func (t definedTermsByName) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t definedTermsByName) Less(i, j int) bool {
+	return strings.ToLower(t[i].term) < strings.ToLower(t[j].term)
+}
+
+// definedTermPattern returns a case insensitive pattern which matches the term as a whole word.
+func definedTermPattern(term string) *regexp.Regexp {
+	wordPattern := regexp.MustCompile(`\A\w|\w\z`)
+	pattern := regexp.QuoteMeta(term)
+	if wordPattern.MatchString(term[:1]) {
+		pattern = `\b` + pattern
+	}
+	if wordPattern.MatchString(term[len(term)-1:]) {
+		pattern = pattern + `\b`
+	}
+	return regexp.MustCompile(`(?i)` + pattern)
+}
+
+// describeTheProvision names a provision in a diagnostic. Text outside of the structured headers
+// block has no provision.
+func describeTheProvision(provision string) string {
+	if provision == "" {
+		return "the text outside of the provisions"
+	}
+	return provision
+}
+
+// scanTheDefinedTerms records the lines of the contents of a file so that the definitions and uses
+// of the defined terms can be found in them later. The firstLine is the number of lines of the file
+// which come before the contents, as for scanTheCrossReferences.
+func scanTheDefinedTerms(contents string, file string, firstLine int) {
+	for i, line := range strings.Split(contents, "\n") {
+		definedTermSources = append(definedTermSources, definedTermSource{file, firstLine + i + 1, line})
+	}
+}
+
+// locateTheDefinition returns the file and line of the nth definition of a term, counting from 0,
+// or an empty file and line 0 if it cannot be found in the files which were read.
+func locateTheDefinition(term string, n int) (string, int) {
+	for _, source := range definedTermSources {
+		for _, match := range definitionPattern.FindAllStringSubmatch(source.text, -1) {
+			if match[1] != term {
+				continue
+			}
+			if n == 0 {
+				return source.file, source.line
+			}
+			n--
+		}
+	}
+	return "", 0
+}
+
+// locateTheUse returns the file and line of the nth use of a defined term written exactly as the
+// text is, counting from 0 and skipping those within a definition, or an empty file and line 0 if
+// it cannot be found in the files which were read. A use which was brought into the document by a
+// mixin cannot be found, as its text is not written in the files.
+func locateTheUse(text string, n int) (string, int) {
+	pattern := definedTermPattern(text)
+	for _, source := range definedTermSources {
+		definitions := definitionPattern.FindAllStringIndex(source.text, -1)
+	uses:
+		for _, use := range pattern.FindAllStringIndex(source.text, -1) {
+			for _, definition := range definitions {
+				if use[0] >= definition[0] && use[1] <= definition[1] {
+					continue uses
+				}
+			}
+			if source.text[use[0]:use[1]] != text {
+				continue
+			}
+			if n == 0 {
+				return source.file, source.line
+			}
+			n--
+		}
+	}
+	return "", 0
+}
+
+// addDefinedTermDiagnostic records a problem with the defined terms, against the file and line on
+// which it was found, with the severity set by DefinedTermChecks.
+func addDefinedTermDiagnostic(file string, line int, format string, args ...interface{}) {
+	if DefinedTermChecks == "off" {
+		return
+	}
+	severity := "warning"
+	if DefinedTermChecks == "error" {
+		severity = "error"
+	}
+	addDiagnostic(severity, file, line, format, args...)
+}
@@ -0,0 +1,156 @@
+package lmd
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// parameterUse is a place in a template where a mixin is filled in or an optional clause begins.
+// Name is the parameter, clause is set for an optional clause and within is the chain of optional
+// clauses which contain the place, from the outermost inwards.
+type parameterUse struct {
+	name   string
+	clause bool
+	within []string
+}
+
+// parameterUsePattern matches the beginning of an optional clause, a mixin, or a square bracket
+// which opens or closes anything else, in the order they are written.
+var parameterUsePattern = regexp.MustCompile(`\[\{\{(\S+?)\}\}|\{\{(\S+?)\}\}|\[|\]`)
+
+// findTheParameterUses walks through the contents and records every use of a mixin or an optional
+// clause, in the order in which they are written, along with the optional clauses which contain
+// each of them. Square brackets which do not begin an optional clause (such as those of markdown
+// links) are matched up so that the closing bracket of an optional clause can be found. Escaped
+// text should be hidden from the contents first.
+func findTheParameterUses(contents string) []parameterUse {
+
+	uses := []parameterUse{}
+	open := []string{}
+	for _, match := range parameterUsePattern.FindAllStringSubmatch(contents, -1) {
+		switch {
+		case match[0] == "]":
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		case match[0] == "[":
+			open = append(open, "")
+		case match[1] != "":
+			uses = append(uses, parameterUse{match[1], true, openClauses(open)})
+			open = append(open, match[1])
+		default:
+			uses = append(uses, parameterUse{match[2], false, openClauses(open)})
+		}
+	}
+	return uses
+}
+
+// openClauses returns the names of the optional clauses among the open brackets.
+func openClauses(open []string) []string {
+	clauses := []string{}
+	for _, clause := range open {
+		if clause != "" {
+			clauses = append(clauses, clause)
+		}
+	}
+	return clauses
+}
+
+// isReachable returns true if the use of a parameter would remain in the document given the answers
+// so far, which is to say that none of the optional clauses which contain it has been turned off.
+// Clauses which have not been answered are taken to be on.
+func (use parameterUse) isReachable(answers map[string]string) bool {
+	for _, clause := range use.within {
+		if answers[clause] == "false" {
+			return false
+		}
+	}
+	return true
+}
+
+// findTheDependencies returns, for each parameter which only matters when certain optional clauses
+// are turned on, the chain of those clauses from the outermost inwards. A parameter depends on a
+// clause when every one of its uses is within that clause, so a parameter which is used anywhere
+// outside of the optional clauses does not depend on any of them and is left out.
+func findTheDependencies(contents string) map[string][]string {
+
+	dependencies := make(map[string][]string)
+	unconditional := make(map[string]bool)
+	for _, use := range findTheParameterUses(contents) {
+		if unconditional[use.name] {
+			continue
+		}
+		if len(use.within) == 0 {
+			unconditional[use.name] = true
+			delete(dependencies, use.name)
+			continue
+		}
+		existing, seen := dependencies[use.name]
+		if !seen {
+			dependencies[use.name] = use.within
+			continue
+		}
+		common := []string{}
+		for _, clause := range existing {
+			if containsTheString(use.within, clause) {
+				common = append(common, clause)
+			}
+		}
+		if len(common) == 0 {
+			unconditional[use.name] = true
+			delete(dependencies, use.name)
+			continue
+		}
+		dependencies[use.name] = common
+	}
+	return dependencies
+}
+
+// dependencyTree turns the dependencies into a tree of the optional clauses, in which each clause
+// lists the parameters (including other clauses) which depend on it directly, that is to say those
+// for which it is the innermost clause they are within.
+func dependencyTree(dependencies map[string][]string) map[string][]string {
+	tree := make(map[string][]string)
+	for name, clauses := range dependencies {
+		clause := clauses[len(clauses)-1]
+		tree[clause] = append(tree[clause], name)
+	}
+	for clause := range tree {
+		sort.Strings(tree[clause])
+	}
+	return tree
+}
+
+// describeTheDependency describes the clauses on which a parameter depends for the comment which
+// follows it in the front matter, as in "only used when is_three_party is true".
+func describeTheDependency(clauses []string) string {
+	if len(clauses) == 1 {
+		return dependencyCommentPrefix + clauses[0] + " is true"
+	}
+	return dependencyCommentPrefix + strings.Join(clauses[:len(clauses)-1], ", ") + " and " + clauses[len(clauses)-1] + " are true"
+}
+
+// containsTheString returns true if the slice contains the string.
+func containsTheString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// findTheParameterOrder returns the names of the mixins and optional clauses in the order in which
+// they are first written in the contents.
+func findTheParameterOrder(contents string) []string {
+	order := []string{}
+	seen := make(map[string]bool)
+	for _, use := range findTheParameterUses(contents) {
+		if !seen[use.name] {
+			seen[use.name] = true
+			order = append(order, use.name)
+		}
+	}
+	return order
+}
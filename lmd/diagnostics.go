@@ -0,0 +1,85 @@
+package lmd
+
+import (
+	"fmt"
+	"log"
+)
+
+// Diagnostic is a problem which legalmarkdown noticed in a template while parsing it. Diagnostics
+// do not stop the parse; they are collected along the way so that they can be reported to the user
+// all at once. File is the file in which the problem was found, Line is the line within that file
+// (or 0 if the line is not known), Severity is either "warning" or "error" and Message explains the
+// problem.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Severity string
+	Message  string
+}
+
+// String formats the diagnostic in the familiar file:line: severity: message form.
+func (d Diagnostic) String() string {
+	location := d.File
+	if location == "" {
+		location = "-"
+	}
+	if d.Line > 0 {
+		location = fmt.Sprintf("%v:%v", location, d.Line)
+	}
+	return fmt.Sprintf("%v: %v: %v", location, d.Severity, d.Message)
+}
+
+// diagnostics holds the diagnostics which have been collected during the current parse job. It is
+// emptied by resetTheDiagnostics whenever a new template is set up.
+var diagnostics []Diagnostic
+
+// Strict stops legalmarkdown from writing any output when an error diagnostic has been
+// collected during the parse job.
+var Strict bool
+
+// resetTheDiagnostics clears the diagnostics, and the cross reference locations and source lines
+// on which some of them are based, left over from any previous parse job, along with any escaped
+// text.
+func resetTheDiagnostics() {
+	diagnostics = []Diagnostic{}
+	crossReferenceSources = []crossReferenceSource{}
+	definedTermSources = []definedTermSource{}
+	escapedText = []string{}
+}
+
+// addWarning records a warning diagnostic.
+func addWarning(file string, line int, format string, args ...interface{}) {
+	addDiagnostic("warning", file, line, format, args...)
+}
+
+// addDiagnostic records a diagnostic with the given severity.
+func addDiagnostic(severity string, file string, line int, format string, args ...interface{}) {
+	diagnostics = append(diagnostics, Diagnostic{file, line, severity, fmt.Sprintf(format, args...)})
+}
+
+// hasErrors returns true if any error diagnostics have been collected.
+func hasErrors() bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+// stopIfStrict reports the diagnostics and stops legalmarkdown before any output is written
+// if it is running in strict mode and errors have been found.
+func stopIfStrict() {
+	if Strict && hasErrors() {
+		reportTheDiagnostics()
+		log.Fatal("Stopping because errors were found in strict mode.")
+	}
+}
+
+// reportTheDiagnostics writes any diagnostics collected during the parse job to the log so that
+// the user sees them on stderr, without disturbing output which may be written to stdout.
+func reportTheDiagnostics() {
+	for _, diagnostic := range diagnostics {
+		log.Println(diagnostic)
+	}
+}
@@ -0,0 +1,90 @@
+package lmd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// escapedText holds the text which has been hidden from the parser by hideTheEscapes for the
+// current parse job, in the order it was hidden. It is emptied by resetTheDiagnostics along with
+// the diagnostics.
+var escapedText []string
+
+// rawRegionPattern matches a region of the template which is kept exactly as it is written,
+// between an `@raw` line and an `@endraw` line.
+var rawRegionPattern = regexp.MustCompile(`(?sm)^@raw[ \t]*\n(.*?)^@endraw[ \t]*$\n?`)
+
+// isWithinARawRegion returns true if the index falls within one of the raw regions of the contents,
+// as given by rawRegionPattern.FindAllStringIndex.
+func isWithinARawRegion(index int, rawRegions [][]int) bool {
+	for _, region := range rawRegions {
+		if index >= region[0] && index < region[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// escapePattern matches a backslash before a character which would otherwise begin a mixin, an
+// optional clause, a cross reference, a footnote or a directive, or before another backslash.
+var escapePattern = regexp.MustCompile(`\\([{}\[\]|@^\\])`)
+
+// escapedLeaderPattern matches a backslash before a structured header at the beginning of a line.
+var escapedLeaderPattern = regexp.MustCompile(`(?m)^\\(l+\.|l[0-9]+\.)`)
+
+// escapeMarkerPattern matches the markers which escapeMarker returns.
+var escapeMarkerPattern = regexp.MustCompile("\x00esc([0-9]+)\x00")
+
+// hideTheEscapes hides the text which the template has escaped from every pass of the parser. The
+// text of each `@raw` region, without its `@raw` and `@endraw` lines, is hidden whole. Then a
+// backslash may be used to escape a single character, so `\{{name}}` is written as `{{name}}`
+// rather than being filled in as a mixin, `\[{{clause}} ...]` is not an optional clause, `\|word|`
+// is not a cross reference and `\l.` at the beginning of a line is not a structured header. The
+// rows of tables are left as they are, as the pipes in them are never taken to be cross references
+// and a backslash before a pipe is how markdown puts a pipe within a cell.
+//
+// Each piece of hidden text is replaced with a marker which none of the passes acts upon, and is
+// put back in place by restoreTheEscapes. A backslash before punctuation is also how markdown
+// escapes it, so it is put back along with the character and the escape works in the output just
+// as it did in the template, while ordinary markdown escapes such as `\[` and `\\` come through
+// unchanged. Only the backslash before `l.`, which markdown would write out, is taken away.
+func hideTheEscapes(contents string) string {
+
+	contents = rawRegionPattern.ReplaceAllStringFunc(contents, func(region string) string {
+		marker := escapeMarker(strings.TrimSuffix(rawRegionPattern.FindStringSubmatch(region)[1], "\n"))
+		if strings.HasSuffix(region, "\n") {
+			return marker + "\n"
+		}
+		return marker
+	})
+
+	lines := strings.Split(contents, "\n")
+	for i, line := range lines {
+		if isATableRow(line) {
+			continue
+		}
+		line = escapedLeaderPattern.ReplaceAllStringFunc(line, func(escaped string) string {
+			return escapeMarker(escaped[1:])
+		})
+		lines[i] = escapePattern.ReplaceAllStringFunc(line, escapeMarker)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// escapeMarker records a piece of hidden text and returns the marker which takes its place.
+func escapeMarker(text string) string {
+	escapedText = append(escapedText, text)
+	return "\x00esc" + strconv.Itoa(len(escapedText)-1) + "\x00"
+}
+
+// restoreTheEscapes puts the text which was hidden by hideTheEscapes back in place of its markers.
+func restoreTheEscapes(contents string) string {
+	return escapeMarkerPattern.ReplaceAllStringFunc(contents, func(marker string) string {
+		index, _ := strconv.Atoi(escapeMarkerPattern.FindStringSubmatch(marker)[1])
+		if index >= len(escapedText) {
+			return marker
+		}
+		return escapedText[index]
+	})
+}
@@ -0,0 +1,195 @@
+package lmd
+
+import (
+	"bufio"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FillInput is where Fill reads the answers to its questions from and FillPrompts is where it
+// writes the questions to. The questions are written to stderr so that the output of Fill may be
+// written to stdout with "-".
+var FillInput io.Reader = os.Stdin
+var FillPrompts io.Writer = os.Stderr
+
+// levelKeyPattern matches the parameters which set the style of a level of the structured headers.
+var levelKeyPattern = regexp.MustCompile(`\Alevel-[0-9]+\z`)
+
+// Fill is the primary function of the fill command which walks whoever is filling in a template
+// through its parameters on the terminal, rather than leaving them to edit the front matter by
+// hand.
+//
+// The parameters are discovered in the same way as for the assemble command (mixins, optional
+// clauses and the styles of the structured headers) and are asked for in the order in which they
+// are written in the template. The description, allowed values and default of each parameter are
+// taken from the schema (see readTheSchema), and whatever value the template or the parameters file
+// already gives a parameter is offered as its default so that pressing Enter keeps it. An answer
+// which does not match the schema is asked for again.
+//
+// Optional clauses are asked as yes or no questions, and once an optional clause has been turned
+// off nothing which is only written within it is asked about, so that a clause nested within
+// another is only asked about when the clause around it has been turned on.
+//
+// Finally the answers are written to the output file as a parameters file which may be passed to
+// the parse and render commands with --parameters, or, if render is set, the template is parsed
+// with the answers and the parsed document is written to the output file instead.
+func Fill(contentsFile string, parametersFile string, outputFile string, render bool) {
+
+	contents, _, parameters := readTheTemplate(contentsFile, parametersFile)
+	schema := readTheSchema(parameters, contentsFile)
+
+	answers := askTheQuestions(contents, parameters, schema, bufio.NewReader(FillInput))
+
+	if !render {
+		answersAsByteArray, _ := yaml.Marshal(nestTheParameters(answers))
+		writeAFile(outputFile, string(answersAsByteArray))
+		reportTheDiagnostics()
+		return
+	}
+
+	parameters = validateTheParameters(mergeParameters(answers, parameters), schema, contentsFile, parametersFile)
+	stopIfStrict()
+	contents, parameters = HandleMixins(contents, parameters)
+
+	headers := SetTheHeaders(contents, parameters)
+	contents = HandleTheHeaders(contents, headers)
+
+	stopIfStrict()
+	writeAFile(outputFile, contents)
+	reportTheDiagnostics()
+}
+
+// askTheQuestions asks for each of the mixins and optional clauses which may still appear in the
+// document given the answers so far, and then for the style of each level of the structured
+// headers, and returns the answers.
+func askTheQuestions(contents string, parameters map[string]string, schema map[string]*parameterSchema, input *bufio.Reader) map[string]string {
+
+	answers := make(map[string]string)
+	searchable := hideTheEscapes(contents)
+
+	for _, use := range findTheParameterUses(searchable) {
+		if _, asked := answers[use.name]; asked || !use.isReachable(answers) {
+			continue
+		}
+		if use.clause {
+			answers[use.name] = askAboutTheClause(use.name, defaultAnswer(use.name, parameters, schema), schema[use.name], input)
+		} else {
+			answers[use.name] = askAboutTheMixin(use.name, defaultAnswer(use.name, parameters, schema), schema[use.name], input)
+		}
+	}
+
+	_, headers, _ := findTheLeaders(searchable, parameters)
+	levels := []string{}
+	for level := range headers {
+		if levelKeyPattern.MatchString(level) {
+			levels = append(levels, level)
+		}
+	}
+	sort.Strings(levels)
+	for _, level := range levels {
+		fmt.Fprintf(FillPrompts, "Style of the %v headers [%v]: ", level, headers[level])
+		answer, _ := readAnAnswer(input)
+		if answer == "" {
+			answer = headers[level]
+		}
+		answers[level] = answer
+	}
+
+	return answers
+}
+
+// askAboutTheClause asks whether an optional clause should be included and returns "true" or
+// "false". Yes and no may be answered with y, yes, true, n, no or false.
+func askAboutTheClause(name string, def string, entry *parameterSchema, input *bufio.Reader) string {
+
+	if entry != nil && entry.description != "" {
+		fmt.Fprintln(FillPrompts, entry.description)
+	}
+	for {
+		fmt.Fprintf(FillPrompts, "Include the optional clause %q? [y/n] (%v): ", name, describeTheDefault(def))
+		answer, more := readAnAnswer(input)
+		switch strings.ToLower(answer) {
+		case "y", "yes", "true":
+			return "true"
+		case "n", "no", "false":
+			return "false"
+		case "":
+			if def != "" || !more {
+				return def
+			}
+		}
+		if !more {
+			return def
+		}
+		fmt.Fprintln(FillPrompts, "Please answer y or n.")
+	}
+}
+
+// askAboutTheMixin asks for the value of a mixin, showing its description and allowed values from
+// the schema, and asks again for as long as the answer does not match the schema.
+func askAboutTheMixin(name string, def string, entry *parameterSchema, input *bufio.Reader) string {
+
+	if entry != nil && entry.description != "" {
+		fmt.Fprintln(FillPrompts, entry.description)
+	}
+	prompt := name
+	if entry != nil && len(entry.allowed) > 0 {
+		prompt = prompt + " (one of: " + strings.Join(entry.allowed, ", ") + ")"
+	}
+	for {
+		fmt.Fprintf(FillPrompts, "%v [%v]: ", prompt, def)
+		answer, more := readAnAnswer(input)
+		if answer == "" {
+			answer = def
+		}
+		if entry == nil || !more {
+			return answer
+		}
+		problems := entry.check(answer)
+		if len(problems) == 0 {
+			return answer
+		}
+		for _, problem := range problems {
+			fmt.Fprintf(FillPrompts, "%v %v\n", name, problem)
+		}
+	}
+}
+
+// defaultAnswer returns the value which is kept when a question is answered with Enter: the value
+// the parameters already give, or failing that the default of the schema.
+func defaultAnswer(name string, parameters map[string]string, schema map[string]*parameterSchema) string {
+	if val := strings.TrimSpace(parameters[name]); val != "" {
+		return val
+	}
+	if entry, exists := schema[name]; exists && entry.hasDefault {
+		return entry.def
+	}
+	return ""
+}
+
+// describeTheDefault describes the default answer of an optional clause for its question.
+func describeTheDefault(def string) string {
+	switch def {
+	case "true":
+		return "yes"
+	case "false":
+		return "no"
+	}
+	return "no default"
+}
+
+// readAnAnswer reads a line from the input and returns it without its surrounding white space. Once
+// the input has run out it returns false, so that the remaining questions take their defaults
+// rather than being asked forever.
+func readAnAnswer(input *bufio.Reader) (string, bool) {
+	line, err := input.ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(FillPrompts)
+	}
+	return strings.TrimSpace(line), err == nil
+}
@@ -0,0 +1,137 @@
+package lmd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// footnote is a note which is referred to from the text of the document. Number is its number
+// throughout the document and text is the text of the note.
+type footnote struct {
+	number int
+	text   string
+}
+
+// Footnotes sets how the notes of a document are written: "footnotes" (the default) writes them as
+// markdown footnotes, which renderers turn into proper footnotes in html, docx and pdf, while
+// "endnotes" numbers them in brackets in the text and lists them under a heading at the end of the
+// document, for plain text.
+var Footnotes = "footnotes"
+
+// footnoteDefinitionPattern matches the definition of a footnote, as in `[^fees]: The fees are set
+// out in the schedule.`, along with any lines which follow it indented by spaces or tabs.
+var footnoteDefinitionPattern = regexp.MustCompile(`(?m)^[ \t]*\[\^([^\]\s]+)\]:[ \t]*(.*(?:\n[ \t]+\S.*)*)\n?`)
+
+// footnoteReferencePattern matches a reference to a footnote which is defined elsewhere, as in
+// `[^fees]`, or a footnote which is written where it is referred to, as in `^[The fees are ...]`.
+var footnoteReferencePattern = regexp.MustCompile(`\[\^([^\]\s]+)\]|\^\[([^\[\]]+)\]`)
+
+// pullOutTheFootnotes removes the definitions of the footnotes from the contents, before the
+// structured headers are parsed and would join them on to the provisions before them, and numbers
+// the footnotes in the order in which they are first referred to throughout the whole document.
+// Each reference is replaced with a marker for the number of its footnote, which is filled in by
+// placeTheFootnotes. A footnote which is referred to but never defined, or defined but never
+// referred to, is reported as a warning. The lines of a definition after the first are indented
+// by four spaces, as markdown expects, and the definitions are taken out of the contents by
+// removeTheFootnoteDefinitions.
+func pullOutTheFootnotes(contents string) (string, []*footnote) {
+
+	definitions := make(map[string]string)
+	for _, definition := range footnoteDefinitionPattern.FindAllStringSubmatch(contents, -1) {
+		if _, exists := definitions[definition[1]]; exists {
+			addWarning("", 0, "footnote [^%v] is defined more than once; the first definition has been used", definition[1])
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(definition[2]), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+		definitions[definition[1]] = strings.Join(lines, "\n    ")
+	}
+	contents = removeTheFootnoteDefinitions(contents)
+
+	notes := []*footnote{}
+	numbers := make(map[string]int)
+	contents = footnoteReferencePattern.ReplaceAllStringFunc(contents, func(reference string) string {
+		match := footnoteReferencePattern.FindStringSubmatch(reference)
+		if match[1] == "" {
+			notes = append(notes, &footnote{len(notes) + 1, strings.TrimSpace(match[2])})
+			return footnoteMarker(len(notes))
+		}
+		if number, numbered := numbers[match[1]]; numbered {
+			return footnoteMarker(number)
+		}
+		text, defined := definitions[match[1]]
+		if !defined {
+			addWarning("", 0, "footnote [^%v] is referred to but never defined", match[1])
+			return reference
+		}
+		notes = append(notes, &footnote{len(notes) + 1, text})
+		numbers[match[1]] = len(notes)
+		return footnoteMarker(len(notes))
+	})
+
+	for label := range definitions {
+		if _, numbered := numbers[label]; !numbered {
+			addWarning("", 0, "footnote [^%v] is defined but never referred to", label)
+		}
+	}
+
+	return contents, notes
+}
+
+// removeTheFootnoteDefinitions takes the definitions of the footnotes out of the contents. A
+// definition which was a paragraph of its own is taken out along with the blank lines which
+// followed it, so that it does not leave a wider gap between the paragraphs around it.
+func removeTheFootnoteDefinitions(contents string) string {
+	blankLines := regexp.MustCompile(`\A(?:[ \t]*\n)+`)
+	matches := footnoteDefinitionPattern.FindAllStringIndex(contents, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		start, end := matches[i][0], matches[i][1]
+		if start == 0 || strings.HasSuffix(contents[:start], "\n\n") {
+			end = end + len(blankLines.FindString(contents[end:]))
+		}
+		contents = contents[:start] + contents[end:]
+	}
+	return contents
+}
+
+// footnoteMarkerPattern matches the markers which footnoteMarker returns.
+var footnoteMarkerPattern = regexp.MustCompile("\x00fn[0-9]+\x00")
+
+// footnoteMarker is what a reference to a footnote is replaced with while the structured headers
+// are parsed. It contains nothing which the parser acts upon.
+func footnoteMarker(number int) string {
+	return "\x00fn" + strconv.Itoa(number) + "\x00"
+}
+
+// placeTheFootnotes replaces the markers left by pullOutTheFootnotes and writes the notes at the
+// end of the contents, as markdown footnotes or as endnotes depending on Footnotes:
+//
+//	footnotes:  The fees[^1] are payable ...    [^1]: The fees are set out in the schedule.
+//	endnotes:   The fees [1] are payable ...    1. The fees are set out in the schedule.
+func placeTheFootnotes(contents string, notes []*footnote) string {
+
+	if len(notes) == 0 {
+		return contents
+	}
+
+	lines := []string{}
+	for _, note := range notes {
+		number := strconv.Itoa(note.number)
+		if Footnotes == "endnotes" {
+			contents = strings.Replace(contents, footnoteMarker(note.number), " ["+number+"]", -1)
+			lines = append(lines, number+". "+note.text)
+		} else {
+			contents = strings.Replace(contents, footnoteMarker(note.number), "[^"+number+"]", -1)
+			lines = append(lines, "[^"+number+"]: "+note.text)
+		}
+	}
+
+	contents = strings.TrimRight(contents, "\n") + "\n\n"
+	if Footnotes == "endnotes" {
+		contents = contents + "Notes\n\n"
+	}
+	return contents + strings.Join(lines, "\n\n") + "\n"
+}
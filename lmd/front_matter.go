@@ -0,0 +1,187 @@
+package lmd
+
+import (
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"strings"
+)
+
+// MarkUnusedParameters sets whether the assemble command marks the parameters of the existing
+// front matter which are no longer used anywhere in the template, with a comment before each one.
+var MarkUnusedParameters = false
+
+// unusedParameterComment is the comment which marks a parameter which is no longer used.
+const unusedParameterComment = "# not used in the template"
+
+// dependencyCommentPrefix begins the comments which annotateTheDependencies adds, so that they may
+// be brought up to date when the front matter is assembled again.
+const dependencyCommentPrefix = "only used when "
+
+// frontMatterKeyPattern matches a line of front matter which begins a top level parameter, with its
+// key (which may be quoted) in the first group.
+var frontMatterKeyPattern = regexp.MustCompile(`\A("[^"]*"|'[^']*'|[^\s#"'\-][^:#]*?)[ \t]*:(?:[ \t]|\z)`)
+
+// updateTheFrontMatter is what the assemble command does when the template already has front matter.
+// Rather than building the front matter afresh, which would put every parameter in alphabetical
+// order and lose whatever comments had been written in it, the front matter is updated in place:
+//
+//   - the parameters which are already in the front matter keep their order, their comments (with
+//     the padding before them) and any nested values exactly as they were written;
+//   - where the value of a mixin, optional clause, level or property has been changed, by a
+//     parameters file or by the default of the schema, the new value replaces the old one;
+//   - the comments which say which optional clauses a parameter depends on are added or brought up
+//     to date (see annotateTheDependencies), unless the parameter already has a comment of its own;
+//   - the parameters which have been discovered in the template but are not yet in the front matter
+//     are added after it under the usual headings, in the order in which they appear; and
+//   - if MarkUnusedParameters is set, a comment is put before each parameter which is no longer used
+//     anywhere in the template.
+func updateTheFrontMatter(frontMatter string, contents string, parameters map[string]string) string {
+
+	searchable := hideTheEscapes(contents)
+	_, mixins := findTheMixins(searchable, parameters)
+	_, optClauses := findTheOptClauses(searchable, parameters)
+	_, headers, styles := findTheLeaders(searchable, parameters)
+	dependencies := findTheDependencies(searchable)
+	order := findTheParameterOrder(searchable)
+
+	// the values of the parameters which may be written on a single line, and every top level key
+	// which the template uses.
+	values := make(map[string]string)
+	used := map[string]bool{"schema": true}
+	for _, section := range []map[string]string{mixins, optClauses, headers, styles} {
+		for key, val := range section {
+			if !strings.Contains(key, ".") {
+				values[key] = val
+			}
+			used[strings.SplitN(key, ".", 2)[0]] = true
+		}
+	}
+
+	written := unmarshallParameters(frontMatter)
+	existing := make(map[string]bool)
+
+	body := strings.TrimRight(frontMatter, "\n")
+	trailing := frontMatter[len(body):]
+	lines := strings.Split(body, "\n")
+	updated := []string{lines[0]}
+	for i := 1; i < len(lines); i++ {
+		match := frontMatterKeyPattern.FindStringSubmatch(lines[i])
+		if match == nil {
+			updated = append(updated, lines[i])
+			continue
+		}
+		key := strings.Trim(match[1], `"'`)
+		existing[key] = true
+
+		// mark the parameter if it is no longer used, or take away the mark if it is used again.
+		marked := updated[len(updated)-1] == unusedParameterComment
+		if MarkUnusedParameters && !used[key] && !marked {
+			updated = append(updated, unusedParameterComment)
+		} else if used[key] && marked {
+			updated = updated[:len(updated)-1]
+		}
+
+		line, comment := splitTheComment(lines[i])
+		writtenLine, writtenComment := line, comment
+		if val, exists := values[key]; exists && val != written[key] && isAScalarLine(lines, i) {
+			lineAsByteArray, _ := yaml.Marshal(map[string]string{key: val})
+			if newLine := strings.TrimSuffix(string(lineAsByteArray), "\n"); !strings.Contains(newLine, "\n") {
+				line = newLine
+			}
+		}
+		if clauses, depends := dependencies[key]; depends && (comment == "" || strings.HasPrefix(comment, dependencyCommentPrefix)) {
+			comment = describeTheDependency(clauses)
+		} else if !depends && strings.HasPrefix(comment, dependencyCommentPrefix) {
+			comment = ""
+		}
+
+		// a line which has not changed is kept exactly as it was written, and a comment which has
+		// not changed keeps the padding which was written before it.
+		switch {
+		case line == writtenLine && comment == writtenComment:
+			line = lines[i]
+		case comment != "" && comment == writtenComment:
+			padding := lines[i][len(writtenLine):]
+			line = line + padding[:strings.Index(padding, "#")] + "# " + comment
+		case comment != "":
+			line = line + "  # " + comment
+		}
+		updated = append(updated, line)
+	}
+
+	// gather the parameters which are not yet in the front matter.
+	newMixins := missingFromTheFrontMatter(mixins, existing)
+	newOptClauses := missingFromTheFrontMatter(optClauses, existing)
+	newHeaders := missingFromTheFrontMatter(headers, existing)
+	newStyles := missingFromTheFrontMatter(styles, existing)
+
+	added := ""
+	if len(newMixins) != 0 {
+		mixinsAsByteArray, _ := yaml.Marshal(orderTheSection(newMixins, order))
+		added = added + "\n\n# Mixins\n" + annotateTheDependencies(string(mixinsAsByteArray), newMixins, dependencies)
+	}
+	if len(newOptClauses) != 0 {
+		optClausesAsByteArray, _ := yaml.Marshal(orderTheSection(newOptClauses, order))
+		added = added + "\n\n# Optional Clauses\n" + annotateTheDependencies(string(optClausesAsByteArray), newOptClauses, dependencies)
+	}
+	if len(newHeaders) != 0 {
+		headersAsByteArray, _ := yaml.Marshal(nestTheParameters(newHeaders))
+		added = added + "\n\n# Structured Headers\n" + string(headersAsByteArray)
+	}
+	if len(newStyles) != 0 {
+		stylesAsByteArray, _ := yaml.Marshal(newStyles)
+		added = added + "\n\n# Properties\n" + string(stylesAsByteArray)
+	}
+
+	return strings.Join(updated, "\n") + strings.TrimRight(added, "\n") + trailing + "---\n\n" + contents
+}
+
+// missingFromTheFrontMatter returns the parameters of a section whose top level keys are not
+// already in the front matter.
+func missingFromTheFrontMatter(section map[string]string, existing map[string]bool) map[string]string {
+	missing := make(map[string]string)
+	for key, val := range section {
+		if !existing[strings.SplitN(key, ".", 2)[0]] {
+			missing[key] = val
+		}
+	}
+	return missing
+}
+
+// splitTheComment separates a line of front matter from the comment at the end of it, if it has one,
+// and returns the line without the comment and the text of the comment without its "#". A "#" only
+// begins a comment when it follows white space and is not within quotes, which only begin a key or
+// a value.
+func splitTheComment(line string) (string, string) {
+	quote := rune(0)
+	for i, char := range line {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case (char == '"' || char == '\'') && (i == 0 || strings.HasSuffix(strings.TrimRight(line[:i], " \t"), ":")):
+			quote = char
+		case char == '#' && i > 0 && (line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t"), strings.TrimSpace(line[i+1:])
+		}
+	}
+	return line, ""
+}
+
+// isAScalarLine returns true if the parameter which begins on the given line has its whole value on
+// that line, rather than a nested map, a list or a block of text on the lines which follow.
+func isAScalarLine(lines []string, i int) bool {
+	line, _ := splitTheComment(lines[i])
+	value := strings.TrimSpace(line[strings.Index(line, ":")+1:])
+	if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+		return false
+	}
+	for _, next := range lines[i+1:] {
+		if strings.TrimSpace(next) == "" {
+			continue
+		}
+		return !(strings.HasPrefix(next, " ") || strings.HasPrefix(next, "\t") || strings.HasPrefix(next, "-"))
+	}
+	return true
+}
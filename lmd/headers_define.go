@@ -0,0 +1,214 @@
+package lmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// levelDefinitionPattern matches the parameters which hold the fields of a level which is defined
+// in its structured form, such as "level-1.style". The fields arrive in this form whether they were
+// written as a nested map in the front matter or written out with the period.
+var levelDefinitionPattern = regexp.MustCompile(`\Alevel-([0-9])\.(.+)\z`)
+
+// levelStyleNames maps the names of the numbering styles which may be given in the structured form
+// of a level to the style integers which are set by defineHeaderStyle. Where defineHeaderStyle has
+// more than one integer for a style (as for "I." and "(I)") the plain one is used, as the
+// parentheses are given by the prefix and suffix of the structured form.
+var levelStyleNames = map[string]int{
+	"number":         0,
+	"upper-roman":    1,
+	"lower-roman":    3,
+	"upper-letter":   5,
+	"lower-letter":   7,
+	"padded-number":  16,
+	"ordinal":        17,
+	"doubled-letter": 18,
+	"bullet":         19,
+	"none":           20,
+}
+
+// levelStyleStarts are the values at which each of the styles start if no start is given.
+var levelStyleStarts = map[int]string{
+	0:  "1",
+	1:  "I",
+	2:  "I",
+	3:  "i",
+	4:  "i",
+	5:  "A",
+	6:  "A",
+	7:  "a",
+	8:  "a",
+	9:  "1",
+	10: "a",
+	11: "A",
+	12: "1",
+	13: "i",
+	14: "I",
+	15: "1",
+	16: "01",
+	17: "First",
+	18: "aa",
+	19: "-",
+	20: "",
+	21: "1",
+}
+
+// applyTheLevelDefinition sets up a header from the fields of the structured form of its level:
+//
+//	level-1:
+//	  prefix: "Article "
+//	  style: upper-roman
+//	  start: 3
+//	  suffix: "."
+//	  separator: " "
+//
+// The prefix is the text before the number (including any opening parenthesis), the style is one of
+// the names in levelStyleNames, the start is the first value of the level and may be given either
+// in the style itself ("III") or as a number (3), the suffix is the text after the number and the
+// separator is what comes between the leader and the text of the provision. Any field which is left
+// out keeps the value it was given by the string form of the level, if there is one, or else
+// defaults to a number starting at 1 followed by a period and a space.
+//
+// A level may also set `inherit: true` to begin its number with the number of its parent, and
+// `join` for the text between the two (a period by default); see assembleTheCompoundLeader. The
+// `heading-style` of a level sets the style of the headings of its provisions; see formatTheHeading.
+//
+// Fields which are not understood, and styles which are not known, are reported as warnings.
+func applyTheLevelDefinition(header *Header, definition map[string]string) {
+
+	level := fmt.Sprintf("level-%v", header.levelNum)
+	suffix, separator := splitTheAfterVal(header.afterVal)
+
+	for field, val := range definition {
+		switch field {
+		case "prefix":
+			header.beforVal = val
+		case "suffix":
+			suffix = val
+		case "separator":
+			separator = val
+		case "inherit":
+			inherit, err := strconv.ParseBool(strings.TrimSpace(val))
+			if err != nil {
+				addWarning("", 0, "%v.inherit %q is not true or false and has been ignored", level, val)
+			}
+			header.inherit = inherit
+		case "join":
+			header.join = val
+		case "heading-style":
+			style := strings.ToLower(strings.TrimSpace(val))
+			if !headingStyles[style] {
+				addWarning("", 0, "%v.heading-style %q is not a known heading style and has been ignored", level, val)
+			} else {
+				header.heading = style
+			}
+		case "style", "start", "indent", "indent-style":
+		default:
+			addWarning("", 0, "%v.%v is not a field of a level and has been ignored", level, field)
+		}
+	}
+
+	if name, given := definition["style"]; given {
+		style, known := levelStyleNames[strings.ToLower(strings.TrimSpace(name))]
+		if !known {
+			addWarning("", 0, "%v.style %q is not a known numbering style and has been ignored", level, name)
+		} else if style != header.style {
+			header.style = style
+			header.currtVal = levelStyleStarts[style]
+		}
+	}
+
+	if start, given := definition["start"]; given {
+		header.currtVal = startingValue(header.style, strings.TrimSpace(start), header.currtVal)
+	}
+
+	if header.inherit {
+		if _, given := definition["join"]; !given {
+			header.join = "."
+		}
+		if _, given := definition["suffix"]; !given {
+			suffix = ""
+		}
+	}
+
+	if header.style == 20 {
+		header.currtVal, suffix, separator = "", "", ""
+	}
+	header.suffix = suffix
+	header.afterVal = suffix + separator
+}
+
+// assembleTheCompoundLeader builds the leader of a provision at a level which inherits the number
+// of its parent. The number of such a level is the number of its parent, the join, its own value
+// and its suffix, so with a join of "." under a parent numbered 1 the level is numbered 1.1, and a
+// level beneath that with a join of "(" and a suffix of ")" is numbered 1.1(a). Because the suffix of
+// an inheriting level is part of its number it is carried down to the levels beneath it. The leader
+// is the prefix of the level, its number and its separator.
+func assembleTheCompoundLeader(header *Header, headers map[string]*Header) string {
+	separator := header.afterVal[len(header.suffix):]
+	return header.beforVal + compoundNumber(header, headers, header.currtVal) + separator
+}
+
+// compoundNumber returns the number of a level as it is used in the compound numbers of the levels
+// beneath it, given the value of the level. A level which does not inherit is numbered by its value
+// alone; its prefix and suffix only dress its own leader. The parent of a level has always been
+// iterated past its own provision by the time its children are reached, so its value is deiterated.
+func compoundNumber(header *Header, headers map[string]*Header, value string) string {
+	if !header.inherit {
+		return value
+	}
+	parent := headerAtLevel(headers, header.levelNum-1)
+	if parent == nil {
+		return value + header.suffix
+	}
+	return compoundNumber(parent, headers, deIterateThisHeader(parent.currtVal, parent.style)) + header.join + value + header.suffix
+}
+
+// headerAtLevel returns the header for a level of the tree, or nil if that level is not defined.
+func headerAtLevel(headers map[string]*Header, level int) *Header {
+	for _, header := range headers {
+		if header.levelNum == level {
+			return header
+		}
+	}
+	return nil
+}
+
+// splitTheAfterVal separates the afterVal of a header into the suffix which follows the number
+// and the separator which follows the suffix, so ". " is split into "." and " ".
+func splitTheAfterVal(afterVal string) (string, string) {
+	suffix := strings.TrimRight(afterVal, " \t")
+	return suffix, afterVal[len(suffix):]
+}
+
+// startingValue turns the start of a level into the value of its style. A start which is already
+// in the style is used as it is. A start which is given as a number, for a style which is not
+// itself numbered, is reached by iterating the style from its first value, so that a start of 3
+// is "c" for lowercase letters and "Third" for ordinals. Zero padded numbers keep the width of
+// the first value of the level, so that a start of 12 is "012" for a level written as "001.".
+func startingValue(style int, start string, first string) string {
+	numbered := regexp.MustCompile(`\A[0-9]+\z`)
+	switch {
+	case start == "" || !numbered.MatchString(start):
+		return start
+	case style == 16:
+		number, _ := strconv.Atoi(start)
+		if first == "" {
+			first = levelStyleStarts[16]
+		}
+		return fmt.Sprintf("%0*d", len(first), number)
+	case style == 0 || style == 9 || style == 12 || style == 15 || style == 21:
+		return start
+	}
+	counter := &Header{style: style, currtVal: levelStyleStarts[style]}
+	if counter.currtVal == "" {
+		return ""
+	}
+	number, _ := strconv.Atoi(start)
+	for i := 1; i < number; i++ {
+		iterateThisHeader(counter)
+	}
+	return counter.currtVal
+}
@@ -0,0 +1,120 @@
+package lmd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tabWidth is the number of characters which a tab is taken to be when an indent which is given
+// in tabs is measured, as for a hanging indent or by a renderer.
+const tabWidth = 4
+
+// Indentation describes how far the provisions of a level are indented, for renderers which lay
+// out the text themselves rather than relying on the spaces and tabs in the output. Characters is
+// the indent in spaces, Tabs is the indent in tabs (only one of the two is ever set) and Hanging is
+// set when the paragraphs of a provision after the first are to line up after its leader rather
+// than with the leader itself.
+type Indentation struct {
+	Characters int
+	Tabs       int
+	Hanging    bool
+}
+
+// IndentFormat is called to indent each provision when it is set, in place of the spaces or tabs
+// which legalmarkdown writes otherwise, so that renderers which lay out the text themselves may
+// indent it in their own units (see Em and Twips). It receives the provision, with its leader and
+// heading in place, and the indentation of its level and returns the text to put in the document.
+var IndentFormat func(provision string, indentation Indentation) string
+
+// Indentation returns the indentation of the header's level.
+func (header *Header) Indentation() Indentation {
+	if header.tabs {
+		return Indentation{0, header.indent, header.hanging}
+	}
+	return Indentation{header.indent, 0, header.hanging}
+}
+
+// Em returns the indent in ems, for html renderers. A character is taken to be half of an em and
+// a tab to be tabWidth characters.
+func (indentation Indentation) Em() float64 {
+	return float64(indentation.Characters+indentation.Tabs*tabWidth) / 2
+}
+
+// Twips returns the indent in twips (twentieths of a point), for docx renderers. An em is taken
+// to be 12 points, so a character is 120 twips.
+func (indentation Indentation) Twips() int {
+	return int(indentation.Em() * 12 * 20)
+}
+
+// indentPattern matches the indent of a level in its structured form: a number of spaces, as
+// `4` or `4 spaces`, or a number of tabs, as `1 tab` or `2 tabs`.
+var indentPattern = regexp.MustCompile(`\A([0-9]+)(?:\s*(spaces?|tabs?))?\z`)
+
+// applyTheLevelIndent sets the indentation of a level from the `indent` and `indent-style` fields
+// of its structured form, which take the place of the indent worked out from no-indent:
+//
+//	level-3:
+//	  indent: 1 tab
+//	  indent-style: hanging
+//
+// The indent is a number of spaces or tabs, or `none` to keep the level to the margin. The indent
+// style is either `block` (the default), where every paragraph of the provision is indented alike,
+// or `hanging`, where the paragraphs after the first are indented further to line up after the
+// leader of the provision.
+func applyTheLevelIndent(header *Header, definition map[string]string) {
+
+	level := "level-" + strconv.Itoa(header.levelNum)
+
+	if indent, given := definition["indent"]; given {
+		indent = strings.ToLower(strings.TrimSpace(indent))
+		switch {
+		case indent == "none" || indent == "":
+			header.indent, header.tabs = 0, false
+		case indentPattern.MatchString(indent):
+			parts := indentPattern.FindStringSubmatch(indent)
+			header.indent, _ = strconv.Atoi(parts[1])
+			header.tabs = strings.HasPrefix(parts[2], "tab")
+		default:
+			addWarning("", 0, "%v.indent %q is not a number of spaces or tabs and has been ignored", level, indent)
+		}
+	}
+
+	if style, given := definition["indent-style"]; given {
+		switch strings.ToLower(strings.TrimSpace(style)) {
+		case "hanging":
+			header.hanging = true
+		case "block":
+			header.hanging = false
+		default:
+			addWarning("", 0, "%v.indent-style %q is not hanging or block and has been ignored", level, style)
+		}
+	}
+}
+
+// indentTheProvision indents a provision whose leader has been replaced. Every line of the
+// provision, including the rows of any tables and the items of any lists which it carries, is
+// given the indent of its level, and when the level has a hanging indent the
+// paragraphs after the first are indented further by the width of the leader. If IndentFormat is
+// set it indents the provision instead.
+func indentTheProvision(block string, header *Header, newLeader string) string {
+
+	if IndentFormat != nil {
+		return IndentFormat(block, header.Indentation())
+	}
+
+	indents := strings.Repeat(" ", header.indent)
+	if header.tabs {
+		indents = strings.Repeat("\t", header.indent)
+	}
+	following := indents
+	if header.hanging {
+		following = indents + strings.Repeat(" ", len([]rune(newLeader)))
+	}
+
+	hasMultipleLines := regexp.MustCompile(`\n([^\n])`)
+	if hasMultipleLines.MatchString(block) {
+		block = hasMultipleLines.ReplaceAllString(block, ("\n" + following + "$1"))
+	}
+	return indents + block
+}
@@ -16,6 +16,12 @@ import (
 // to. BeforeVal is the string which is placed before the currrent value. CurrtVal is the
 // current value of the header which is iterated as the tree parser performs its work.
 // AfterVal is the string which goes after the currtVal -- typically it is only one char.
+// Inherit is set for levels which begin their number with the number of their parent, and
+// join is the string which goes between the two. Suffix is the part of the afterVal which
+// follows the number, without the separator which comes before the text of the provision.
+// Tabs is set when the indent is a number of tabs rather than spaces, and hanging is set when
+// the paragraphs of a provision after the first are indented to line up after its leader.
+// Heading is the style in which the headings of the provisions at the level are set.
 type Header struct {
 	trigger  string
 	reset    bool
@@ -26,6 +32,12 @@ type Header struct {
 	beforVal string
 	currtVal string
 	afterVal string
+	inherit  bool
+	join     string
+	suffix   string
+	tabs     bool
+	hanging  bool
+	heading  string
 }
 
 // SetTheHeaders is the primary parser of structured headers. It parses the styles and header
@@ -41,9 +53,13 @@ type Header struct {
 // Third it parses the "no-reset" parameter to determine which of the headers are not to be
 // reset. These are also put into a slice.
 //
+// Fourth it takes the "heading-style" parameter which sets the style of the headings of the
+// provisions.
+//
 // Finally the function calls the main parsing function "parseHeaders" which returns
-// the map of structs and triggers for each of the relevant headers by the parser. This
-// map is what is returned to the calling function.
+// the map of structs and triggers for each of the relevant headers by the parser. The
+// heading styles are then set on any levels which have not been given their own and the
+// map is returned to the calling function.
 func SetTheHeaders(contents string, parameters map[string]string) map[string]*Header {
 	levelStyle := parseLevelStyle(parameters["level-style"])
 	delete(parameters, "level-style")
@@ -51,7 +67,10 @@ func SetTheHeaders(contents string, parameters map[string]string) map[string]*He
 	delete(parameters, "no-indent")
 	resetSlice := parseResets(parameters["no-reset"])
 	delete(parameters, "no-reset")
+	headingStyle := parameters["heading-style"]
+	delete(parameters, "heading-style")
 	headers := parseHeaders(parameters, levelStyle, indentSlice, resetSlice)
+	setTheHeadingStyles(headers, headingStyle)
 	return headers
 }
 
@@ -75,29 +94,48 @@ func parseResets(resets string) []string {
 	return resetSlice
 }
 
-// set up structs for the headers and put those into a map for use by the parser
+// set up structs for the headers and put those into a map for use by the parser. a level may be
+// defined by a string which is parsed by defineHeaderStyle, by the fields of its structured form
+// which are applied by applyTheLevelDefinition, or by both in which case the fields override
+// the parts of the string which they name.
 func parseHeaders(parameters map[string]string, levelStyle bool, indentSlice []string, resetSlice []string) map[string]*Header {
 
 	var header *Header
 	headers := make(map[string]*Header)
 
-	// set the defaults based on parsing the params.
+	// set the defaults based on parsing the params. the fields of levels which are defined in
+	// their structured form are gathered up separately and applied once the level is set up.
+	definitions := make(map[int]map[string]string)
+	for paramKey, paramVal := range parameters {
+		if levelDefinitionPattern.MatchString(paramKey) {
+			field := levelDefinitionPattern.FindStringSubmatch(paramKey)
+			level, _ := strconv.Atoi(field[1])
+			if definitions[level] == nil {
+				definitions[level] = make(map[string]string)
+			}
+			definitions[level][field[2]] = paramVal
+			delete(parameters, paramKey)
+		}
+	}
+	for level := range definitions {
+		if _, exists := parameters["level-"+strconv.Itoa(level)]; !exists {
+			parameters["level-"+strconv.Itoa(level)] = ""
+		}
+	}
+
 	for paramKey, paramVal := range parameters {
 
 		header = new(Header)
 		header.levelNum, _ = strconv.Atoi(paramKey[len(paramKey)-1:])
 
-		if levelStyle {
-			header.trigger = (strings.Repeat("l", header.levelNum) + ".")
-		} else {
-			header.trigger = ("l" + strconv.Itoa(header.levelNum) + ".")
-		}
-
-		header.indent = (2 * header.levelNum)
+		header.trigger = levelTrigger(header.levelNum, levelStyle)
 
 		header.reset = true
 
 		header.style, header.beforVal, header.currtVal, header.afterVal = defineHeaderStyle(paramVal)
+		if definitions[header.levelNum] != nil {
+			applyTheLevelDefinition(header, definitions[header.levelNum])
+		}
 		header.resetVal = header.currtVal
 
 		headers[header.trigger] = header
@@ -110,26 +148,43 @@ func parseHeaders(parameters map[string]string, levelStyle bool, indentSlice []s
 		}
 	}
 
-	// set and correct indents
+	// set and correct indents. level 1 is not indented and each level beneath it is indented
+	// two spaces further than the level above it, but levels which are listed in no-indent are
+	// kept to the margin and do not push the levels beneath them any further in.
+	noIndent := make(map[string]bool)
 	for _, indnt := range indentSlice {
-		if headers[indnt] != nil {
-			headers[indnt].indent = 0
-		}
+		noIndent[indnt] = true
 	}
 	for _, head := range headers {
-		baseNum := len(indentSlice)
-		if head.indent != 0 && baseNum != 0 {
-			head.indent = (head.indent - (2 * baseNum))
+		head.indent = 0
+		if noIndent[head.trigger] {
+			continue
 		}
-		if head.indent < 0 {
-			head.indent = 0
+		for level := 2; level <= head.levelNum; level++ {
+			if !noIndent[levelTrigger(level, levelStyle)] {
+				head.indent = head.indent + 2
+			}
+		}
+	}
+	for level, definition := range definitions {
+		if header := headerAtLevel(headers, level); header != nil {
+			applyTheLevelIndent(header, definition)
 		}
 	}
 
 	return headers
 }
 
-// defineHeaderStyle sets the style integer according to the 10 relevant types which are
+// levelTrigger returns the trigger for a level in the "llll." style or, if levelStyle is false, in
+// the "l4." style.
+func levelTrigger(level int, levelStyle bool) string {
+	if levelStyle {
+		return strings.Repeat("l", level) + "."
+	}
+	return "l" + strconv.Itoa(level) + "."
+}
+
+// defineHeaderStyle sets the style integer according to the relevant types which are
 // allowed in legalmarkdown. type1 sets the *Header.style to 1 and is for uppercase
 // roman numerals followed by a period. type2 sets the *Header.style to 2 and is for
 // uppercase roman numerals encased in parentheses. type3 sets the *Header.style to
@@ -143,6 +198,18 @@ func parseHeaders(parameters map[string]string, levelStyle bool, indentSlice []s
 // parentheses. type0 sets the *Header.style to 0 and is for numbers followed by a
 // period. This is also the dot.
 //
+// The remaining types were added later and so carry higher numbers. type10 and type11
+// are for lowercase and uppercase letters followed by a right parenthesis only, type12
+// is for numbers followed by a right parenthesis only and type13 and type14 are for
+// lowercase and uppercase roman numerals followed by a right parenthesis only. type15 is
+// for numbers in square brackets. type16 is for zero padded numbers followed by a period,
+// which keep their width as they are iterated. type17 is for spelled out ordinals such as
+// First, Second, Third, which keep the capitalization they were given. type18 is for
+// doubled letters such as aa., bb., cc. or (aa), (bb), (cc). type19 is for bullets, which
+// are the same for every provision, and type20 is for provisions which are given no
+// leader at all (`none`). type21 is for numbers which are not followed by anything, such
+// as "§ 1" or "Section 1".
+//
 // in addition to returning the style integer, the following strings are parsed from
 // the parameter which is passed to the function: the *Header.beforVal, the *Header.
 // currtVal and the *Header.afterVal all of which are simply pulled from the string
@@ -162,28 +229,67 @@ func defineHeaderStyle(h string) (int, string, string, string) {
 	type9 := regexp.MustCompile(`\(([0-9]+)\)\z`)     // {{ (1) }}
 	type0 := regexp.MustCompile(`([0-9]+)\.\z`)       // {{ 1. }} ... also default
 
+	type10 := regexp.MustCompile(`([a-z]+)\)\z`)                        // {{  a) }}
+	type11 := regexp.MustCompile(`([A-Z]+)\)\z`)                        // {{  A) }}
+	type12 := regexp.MustCompile(`([0-9]+)\)\z`)                        // {{  1) }}
+	type13 := regexp.MustCompile(`([ivxlcdm]+)\)\z`)                    // {{  i) }}
+	type14 := regexp.MustCompile(`([IVXLCDM]+)\)\z`)                    // {{  I) }}
+	type15 := regexp.MustCompile(`\[([0-9]+)\]\z`)                      // {{ [1] }}
+	type16 := regexp.MustCompile(`(0[0-9]+)\.\z`)                       // {{ 01. }}
+	type17 := regexp.MustCompile(`([A-Za-z]+(?:-[A-Za-z]+)?)([.:]?)\z`) // {{ First }}
+	type18 := regexp.MustCompile(`(\(?)([a-zA-Z]{2,})([.)])\z`)         // {{ aa. (aa) }}
+	type19 := regexp.MustCompile(`\A\s*([-*+•])\s*\z`)                  // {{ - }}
+	type20 := regexp.MustCompile(`(?i)\A\s*none\s*\z`)                  // {{ none }}
+	type21 := regexp.MustCompile(`\A(.*(?:\s|§))([0-9]+)\z`)            // {{ § 1 }}
+
 	// now run through the sequence
 	switch {
+	case type17.MatchString(h) && from_ordinal_to_arabic(type17.FindStringSubmatch(h)[1]) > 0:
+		match := type17.FindStringSubmatch(h)
+		return 17, type17.ReplaceAllString(h, ""), match[1], match[2] + " "
+	case type18.MatchString(h) && is_doubled_lettering(type18.FindStringSubmatch(h)[2]):
+		match := type18.FindStringSubmatch(h)
+		return 18, type18.ReplaceAllString(h, "") + match[1], match[2], match[3] + " "
+	case type19.MatchString(h):
+		return 19, "", type19.FindStringSubmatch(h)[1], " "
+	case type20.MatchString(h):
+		return 20, "", "", ""
 	case type1.MatchString(h):
 		return 1, type1.ReplaceAllString(h, ""), type1.FindAllStringSubmatch(h, -1)[0][1], ". "
 	case type2.MatchString(h):
 		return 2, (type2.ReplaceAllString(h, "") + "("), type2.FindAllStringSubmatch(h, -1)[0][1], ") "
+	case type14.MatchString(h):
+		return 14, type14.ReplaceAllString(h, ""), type14.FindStringSubmatch(h)[1], ") "
 	case type3.MatchString(h):
 		return 3, type3.ReplaceAllString(h, ""), type3.FindAllStringSubmatch(h, -1)[0][1], ". "
 	case type4.MatchString(h):
 		return 4, (type4.ReplaceAllString(h, "") + "("), type4.FindAllStringSubmatch(h, -1)[0][1], ") "
+	case type13.MatchString(h):
+		return 13, type13.ReplaceAllString(h, ""), type13.FindStringSubmatch(h)[1], ") "
 	case type5.MatchString(h):
 		return 5, type5.ReplaceAllString(h, ""), type5.FindAllStringSubmatch(h, -1)[0][1], ". "
 	case type6.MatchString(h):
 		return 6, (type6.ReplaceAllString(h, "") + "("), type6.FindAllStringSubmatch(h, -1)[0][1], ") "
+	case type11.MatchString(h):
+		return 11, type11.ReplaceAllString(h, ""), type11.FindStringSubmatch(h)[1], ") "
 	case type7.MatchString(h):
 		return 7, type7.ReplaceAllString(h, ""), type7.FindAllStringSubmatch(h, -1)[0][1], ". "
 	case type8.MatchString(h):
 		return 8, (type8.ReplaceAllString(h, "") + "("), type8.FindAllStringSubmatch(h, -1)[0][1], ") "
+	case type10.MatchString(h):
+		return 10, type10.ReplaceAllString(h, ""), type10.FindStringSubmatch(h)[1], ") "
 	case type9.MatchString(h):
 		return 9, (type9.ReplaceAllString(h, "") + "("), type9.FindAllStringSubmatch(h, -1)[0][1], ") "
+	case type12.MatchString(h):
+		return 12, type12.ReplaceAllString(h, ""), type12.FindStringSubmatch(h)[1], ") "
+	case type15.MatchString(h):
+		return 15, (type15.ReplaceAllString(h, "") + "["), type15.FindStringSubmatch(h)[1], "] "
+	case type16.MatchString(h):
+		return 16, type16.ReplaceAllString(h, ""), type16.FindStringSubmatch(h)[1], ". "
 	case type0.MatchString(h):
 		return 0, type0.ReplaceAllString(h, ""), type0.FindAllStringSubmatch(h, -1)[0][1], ". "
+	case type21.MatchString(h):
+		return 21, type21.FindStringSubmatch(h)[1], type21.FindStringSubmatch(h)[2], " "
 	default:
 		return 0, "", "1", ". "
 	}
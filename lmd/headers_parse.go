@@ -8,6 +8,10 @@ import (
 
 // HandleTheHeaders is the primary parser function for parsing a block of structured headers.
 //
+// Before anything else the footnotes are pulled out of the contents and numbered throughout the
+// document, so that their definitions are not joined on to the provisions by splitTheBlock. They
+// are placed back at the end of the document once the table of contents has been built.
+//
 // The function begins by calling the findTheBlock function with the full contents of the file.
 // This function returns a boolean which tells the HandleTheHeaders function whether there is
 // a block to parse or not. If there is no block, this function simply returns the full contents
@@ -28,21 +32,90 @@ import (
 // as determined by the trees established within the template file. This function returns a string
 // which is the new block reassembled with the structured headers emplaced.
 //
-// Finally, the contents are reassembled by adding the pre_block variable to the block and post_block
-// variables which is a long string that is returned to the calling function.
+// Finally, the contents are reassembled by adding the pre_block variable to the block and
+// post_block variables. Any schedules, exhibits or annexes which follow the body of the document
+// are parsed in the same way, each with its numbering started afresh, and placed after the body
+// under their headings. The cross references which are staked within an attachment are replaced
+// within it first, so that they give the leader of the provision alone, and elsewhere in the
+// document they also name the attachment, as in "Section 2 of Schedule 1". Within an attachment the
+// cross references to the provisions of the body name the body, as in "Section 2 of this Agreement"
+// (see BodyReference), so that they are not mistaken for the attachment's own provisions. The
+// defined terms are checked and any `@defined-terms` lines are replaced with the index of defined
+// terms, then any `@toc` lines are replaced with the table of contents. The cross references which
+// were staked within the blocks, and those of the attachments, are then replaced throughout the
+// whole of the reassembled contents, so that any part of the document may refer to the provisions
+// in any other. Any cross references which could not be replaced are reported as diagnostics, any
+// text which was escaped is put back in place and the long string is returned to the calling
+// function.
 func HandleTheHeaders(contents string, headers map[string]*Header) string {
 
+	toc := wantsATableOfContents(contents)
+	contents, notes := pullOutTheFootnotes(contents)
+	body, attachments := splitTheAttachments(contents)
+
+	contents, crossref, entries, termParts := handleThePart(body, headers, toc)
+	bodyCrossref := referencesFromAnAttachment(crossref)
+
+	for _, part := range attachments {
+		resetTheHeaders(headers)
+		text, partCrossref, _, partTermParts := handleThePart(strings.TrimLeft(part.text, "\n"), headers, false)
+		text = replaceTheCrossReferences(text, partCrossref)
+		text = replaceTheCrossReferences(text, bodyCrossref)
+
+		heading := part.heading()
+		if toc {
+			heading = anchorTheAttachment(heading, part)
+			entries = append(entries, part.tocEntry())
+		}
+		contents = strings.TrimRight(contents, "\n") + "\n\n" + heading + "\n\n" + strings.TrimLeft(text, "\n")
+
+		for pointer, reference := range partCrossref {
+			reference.attachment = part.leader()
+			if existing, staked := crossref[pointer]; staked {
+				checkTheDuplicateStake(pointer, existing)
+				reference.duplicate = true
+			}
+			crossref[pointer] = reference
+		}
+		pointer, reference := part.crossReference()
+		if existing, staked := crossref[pointer]; staked {
+			checkTheDuplicateStake(pointer, existing)
+			reference.duplicate = true
+		}
+		crossref[pointer] = reference
+
+		for _, termPart := range partTermParts {
+			termParts = append(termParts, definedTermPart{part.provisionName(termPart.provision), termPart.text})
+		}
+	}
+
+	contents = handleTheDefinedTerms(contents, termParts)
+	contents = replaceTheTableOfContents(contents, entries)
+	contents = placeTheFootnotes(contents, notes)
+	contents = replaceTheCrossReferences(contents, crossref)
+	checkTheDanglingReferences(contents)
+
+	return restoreTheEscapes(contents)
+
+}
+
+// handleThePart runs the structured headers of a single part of the document, which is either the
+// body or an attachment. It returns the part with its block parsed, the cross references staked
+// within it, the entries for the table of contents and the pieces of the part which are checked
+// for defined terms. A part without a block is returned as it is.
+func handleThePart(contents string, headers map[string]*Header, toc bool) (string, map[string]*crossReference, []tocEntry, []definedTermPart) {
+
 	to_run, pre_block, block, post_block := findTheBlock(contents)
 	if !to_run {
-		return contents
+		return contents, make(map[string]*crossReference), []tocEntry{}, []definedTermPart{{"", contents}}
 	}
 
 	blockAsSlice, blockBase := splitTheBlock(block)
 
-	block = runTheHeaders(headers, blockAsSlice, blockBase)
+	block, crossref, entries := runTheHeaders(headers, blockAsSlice, blockBase, toc)
 	contents = pre_block + "\n" + block + "\n\n" + post_block
 
-	return contents
+	return contents, crossref, entries, definedTermParts(pre_block, blockAsSlice, post_block, entries)
 
 }
 
@@ -66,6 +139,11 @@ func findTheBlock(contents string) (bool, string, string, string) {
 // that block, taking out empty lines from the text and adding lines which do not begin with the
 // header pattern to the last element in the assembled slice. it returns a copy of the assembled slice as
 // well as a copy of the slice which only has the leaders (which is needed during the tree parsing phase.
+//
+// Each line of text is usually made a paragraph of its own, but the lines of a markdown table, list
+// or quote are kept together as they were written so that a provision may carry them intact, and a
+// region fenced with `~~~` is kept exactly as it was written, blank lines and all. See
+// blockContentKind for how these lines are recognised.
 func splitTheBlock(block string) ([]string, []string) {
 
 	blockAsSlice := []string{}
@@ -75,25 +153,68 @@ func splitTheBlock(block string) ([]string, []string) {
 	headerPatternNew := regexp.MustCompile(`\Al[0-9]+\.`)
 	blankPattern := regexp.MustCompile(`\A\s*\z`)
 
+	previousKind := ""
+	fenced := false
 	for _, line := range strings.Split(block, "\n") {
+		if fenced {
+			blockAsSlice[len(blockAsSlice)-1] = (blockAsSlice[len(blockAsSlice)-1] + "\n" + line)
+			fenced = blockContentKind(line, "") != "fence"
+			continue
+		}
 		if headerPatternOld.MatchString(line) {
 			blockAsSlice = append(blockAsSlice, line)
 			leader := strings.TrimSpace(headerPatternOld.FindAllString(line, 1)[0])
 			blockBase = append(blockBase, leader)
+			previousKind = ""
 		} else if headerPatternNew.MatchString(line) {
 			blockAsSlice = append(blockAsSlice, line)
 			leader := strings.TrimSpace(headerPatternNew.FindAllString(line, 1)[0])
 			blockBase = append(blockBase, leader)
+			previousKind = ""
 		} else if blankPattern.MatchString(line) {
+			previousKind = ""
 			continue
 		} else {
-			blockAsSlice[len(blockAsSlice)-1] = (blockAsSlice[len(blockAsSlice)-1] + "\n\n" + line)
+			kind := blockContentKind(line, previousKind)
+			if kind != "" && kind != "fence" && kind == previousKind {
+				blockAsSlice[len(blockAsSlice)-1] = (blockAsSlice[len(blockAsSlice)-1] + "\n" + line)
+			} else {
+				blockAsSlice[len(blockAsSlice)-1] = (blockAsSlice[len(blockAsSlice)-1] + "\n\n" + line)
+			}
+			fenced = kind == "fence"
+			previousKind = kind
 		}
 	}
 
 	return blockAsSlice, blockBase
 }
 
+// blockContentKind returns the kind of markdown structure which a line of a provision belongs to:
+// "table" for the rows of a table, "list" for the items of a bullet or numbered list, "quote" for
+// the lines of a block quote and "fence" for a `~~~` fence. A line which is indented and follows a
+// list or a quote continues it. An empty string is returned for a line of plain text.
+func blockContentKind(line string, previousKind string) string {
+	tablePattern := regexp.MustCompile(`\A\s*\|`)
+	listPattern := regexp.MustCompile(`\A\s*(?:[-*+]|[0-9]+[.)])\s`)
+	quotePattern := regexp.MustCompile(`\A\s*>`)
+	fencePattern := regexp.MustCompile(`\A\s*~~~`)
+	continuationPattern := regexp.MustCompile(`\A[ \t]+\S`)
+
+	switch {
+	case fencePattern.MatchString(line):
+		return "fence"
+	case tablePattern.MatchString(line):
+		return "table"
+	case listPattern.MatchString(line):
+		return "list"
+	case quotePattern.MatchString(line):
+		return "quote"
+	case (previousKind == "list" || previousKind == "quote") && continuationPattern.MatchString(line):
+		return previousKind
+	}
+	return ""
+}
+
 // runTheHeaders is the primary parsing function. It takes a map of the headers which have keys of
 // the triggers to look for along with pointers to the Header structs for that particular header.
 // It also takes the blockAsSlice slice which is the main contents of the block broken into the block's
@@ -109,14 +230,22 @@ func splitTheBlock(block string) ([]string, []string) {
 // style is old style or new.
 //
 // After these preliminaries are established the function loops through the blockAsSlice first calling
-// the replaceTheLeader function and then calling the iterateTheLeader function. The result of this
+// the applyTheLeaderModifier function to handle any manual numbering of the provision, then the
+// replaceTheLeader function and then the iterateTheLeader function. The result of this
 // parsing is then placed back into the blockAsSlice.
 //
-// Finally the function sends the map of established cross references and the reformulated blockAsSlice
-// to the collateTheBlock function before returning to the calling function.
-func runTheHeaders(headers map[string]*Header, blockAsSlice []string, blockBase []string) string {
-
-	crossref := make(map[string]string)
+// The function also records an entry for each provision which is used to build the table of
+// contents and the index of defined terms. When the toc boolean is true it places an anchor after
+// the leader of each provision for the table of contents to link to.
+//
+// Finally the function sends the reformulated blockAsSlice to the collateTheBlock function and returns
+// the collated block along with the map of established cross references and the entries for the
+// table of contents to the calling function.
+func runTheHeaders(headers map[string]*Header, blockAsSlice []string, blockBase []string, toc bool) (string, map[string]*crossReference, []tocEntry) {
+
+	crossref := make(map[string]*crossReference)
+	entries := []tocEntry{}
+	anchors := make(map[int]string)
 	headerPatternOld := regexp.MustCompile(`\Al+.`)
 	headerPatternNew := regexp.MustCompile(`\Al[0-9]+.`)
 	oldStyle := true
@@ -126,18 +255,29 @@ func runTheHeaders(headers map[string]*Header, blockAsSlice []string, blockBase
 	}
 
 	for i, block := range blockAsSlice {
+		var leader string
 		if oldStyle {
-			leader := headerPatternOld.FindAllString(block, 1)[0]
+			leader = headerPatternOld.FindAllString(block, 1)[0]
+		} else {
+			leader = headerPatternNew.FindAllString(block, 1)[0]
+		}
+		block = applyTheLeaderModifier(leader, headers[leader], block)
+		if headers[leader] != nil {
+			entry := makeATableOfContentsEntry(leader, headers, block, oldStyle, anchors)
+			entry.index = i
 			block, crossref = replaceTheLeader(leader, headers, block, crossref, oldStyle)
+			if toc {
+				block = anchorTheProvision(block, entry)
+			}
+			entries = append(entries, entry)
 		} else {
-			leader := headerPatternNew.FindAllString(block, 1)[0]
 			block, crossref = replaceTheLeader(leader, headers, block, crossref, oldStyle)
 		}
 		iterateTheLeader(headers, blockBase, i)
 		blockAsSlice[i] = block
 	}
 
-	return collateTheBlock(blockAsSlice, crossref)
+	return collateTheBlock(blockAsSlice), crossref, entries
 }
 
 // replaceTheLeader has the longest function signature in all of legalmarkdown. It accepts a
@@ -149,50 +289,60 @@ func runTheHeaders(headers map[string]*Header, blockAsSlice []string, blockBase
 // If the header returned from the map of headers is nil it simply returns not performing
 // any of its parsing.
 //
-// Then the function checks whether there is a pre or preval suffix in the beforeVal for the
-// header. If that is the case then the assemblePreVal function is called which is a specialized
-// function that requires more computation than is necessary for a normal structured headers
-// parsing function. If there is no pre or preval then the newLeader variable is simply the
-// collation of the current header's beforeVal currtVal and afterVal strings.
-//
-// Once the newLeader is established either via a simple collation or via the assemblePreVal
-// function then the function checks whether there is a cross reference in the block by calling
+// Then the function calls assembleTheLeader to establish the newLeader. Once the newLeader is
+// established the function checks whether there is a cross reference in the block by calling
 // the handleCrossReferences function.
 //
-// Next the function replaces the leader in the block, tightens up the strings and sets the
-// indents to the appropriate level. Finally, the assembled block and the cross references map
-// is returned to the calling function.
-func replaceTheLeader(leader string, headers map[string]*Header, block string, crossref map[string]string, oldStyle bool) (string, map[string]string) {
+// Next the function replaces the leader in the block, tightens up the space between the leader and
+// the text (leaving the rest of the text as cleanUpPostMixins left it), sets the heading of the
+// provision in the style of its level and sets the indents to the appropriate level. Finally, the
+// assembled block and the cross references map is returned to the calling function.
+func replaceTheLeader(leader string, headers map[string]*Header, block string, crossref map[string]*crossReference, oldStyle bool) (string, map[string]*crossReference) {
 
-	var newLeader string
 	header := headers[leader]
 	if header == nil {
 		return block, crossref
 	}
 
-	thisBeforVal := strings.TrimSpace(header.beforVal)
-
-	if strings.HasSuffix(thisBeforVal, "pre") || strings.HasSuffix(thisBeforVal, "pre (") || strings.HasSuffix(thisBeforVal, "preval") {
-		newLeader = assemblePreVal(leader, headers, false, oldStyle)
-	} else {
-		newLeader = header.beforVal + header.currtVal + header.afterVal
-	}
+	newLeader := assembleTheLeader(leader, headers, oldStyle)
 
 	leader, crossref = handleCrossReferences(leader, newLeader, block, crossref, oldStyle)
 
+	if newLeader == "" {
+		leader = leader + " "
+	}
 	block = strings.Replace(block, leader, newLeader, 1)
-	block = strings.Replace(block, "  ", " ", -1)
-
-	indents := strings.Repeat(" ", header.indent)
-	hasMultipleLines := regexp.MustCompile(`\n\n`)
-	if hasMultipleLines.MatchString(block) {
-		block = hasMultipleLines.ReplaceAllString(block, ("\n\n" + indents))
+	if strings.HasSuffix(newLeader, " ") {
+		block = newLeader + strings.TrimLeft(block[len(newLeader):], " ")
 	}
-	block = indents + block
+	block = formatTheHeading(block, header, newLeader)
+
+	block = indentTheProvision(block, header, newLeader)
 
 	return block, crossref
 }
 
+// assembleTheLeader builds the text which will replace a leader in the block. Levels which inherit
+// the number of their parent are built by assembleTheCompoundLeader. Otherwise the function checks
+// whether there is a pre or preval suffix in the beforeVal for the header. If that is the case then
+// the assemblePreVal function is called which is a specialized function that requires more
+// computation than is necessary for a normal structured headers parsing function. If there is no
+// pre or preval then the newLeader is simply the collation of the current header's beforeVal
+// currtVal and afterVal strings.
+func assembleTheLeader(leader string, headers map[string]*Header, oldStyle bool) string {
+
+	header := headers[leader]
+	if header.inherit {
+		return assembleTheCompoundLeader(header, headers)
+	}
+	thisBeforVal := strings.TrimSpace(header.beforVal)
+
+	if strings.HasSuffix(thisBeforVal, "pre") || strings.HasSuffix(thisBeforVal, "pre (") || strings.HasSuffix(thisBeforVal, "preval") {
+		return assemblePreVal(leader, headers, false, oldStyle)
+	}
+	return header.beforVal + header.currtVal + header.afterVal
+}
+
 // assemblePreVal is a complex function which handles the assembly of the newLeader variable
 // for the replaceTheLeader function when the header has a pre or preval call. There are two
 // main challenges that this function has to overcome. The first is that the level above the
@@ -274,8 +424,10 @@ func assemblePreVal(leader string, headers map[string]*Header, nested bool, oldS
 // the block to determine whether the appropriate regular expression is matched (depending on
 // whether it is old or new style headers) and if a match is found the appropriate newLeader
 // that has been assembled by the replaceTheLeader function is placed into the map along with
-// the trigger for the cross reference which is pulled out of the regular expression.
-func handleCrossReferences(leader string, newLeader string, block string, crossref map[string]string, oldStyle bool) (string, map[string]string) {
+// the trigger for the cross reference which is pulled out of the regular expression. The
+// heading of the provision is recorded alongside the leader for use by |key:title| references.
+// If the trigger has already been staked by another provision this is reported as a diagnostic.
+func handleCrossReferences(leader string, newLeader string, block string, crossref map[string]*crossReference, oldStyle bool) (string, map[string]*crossReference) {
 
 	var cross string
 	var crossTmp string
@@ -289,10 +441,12 @@ func handleCrossReferences(leader string, newLeader string, block string, crossr
 		cross = hasCrossRef.FindAllStringSubmatch(block, 1)[0][1]
 		crossTmp = strings.TrimSpace(newLeader)
 		if strings.HasSuffix(crossTmp, ".") {
-			crossref[cross] = crossTmp[:len(crossTmp)-1]
-		} else {
-			crossref[cross] = crossTmp
+			crossTmp = crossTmp[:len(crossTmp)-1]
+		}
+		if existing, staked := crossref[cross]; staked {
+			checkTheDuplicateStake(cross, existing)
 		}
+		crossref[cross] = &crossReference{leader: crossTmp, title: provisionHeading(block[len(leader):]), duplicate: crossref[cross] != nil}
 	}
 
 	return leader, crossref
@@ -323,6 +477,38 @@ func iterateTheLeader(headers map[string]*Header, blockBase []string, index int)
 
 }
 
+// applyTheLeaderModifier handles the manual numbering of a provision. A leader may be followed
+// directly by a modifier: `ll.=14` sets the number of the provision to 14 and `ll.+2` skips two
+// numbers, so that a provision which would have been Section 5 becomes Section 7. The value given
+// with `=` may be a number or a value in the style of the level (as `ll.=C`). Either way the
+// provisions which follow carry on from the new number. The modifier is removed from the block.
+func applyTheLeaderModifier(leader string, header *Header, block string) string {
+
+	if !leaderModifierPattern.MatchString(block) {
+		return block
+	}
+	modifier := leaderModifierPattern.FindStringSubmatch(block)
+	block = leader + block[len(modifier[0]):]
+	if header == nil {
+		return block
+	}
+
+	if modifier[2] == "=" {
+		header.currtVal = startingValue(header.style, modifier[3], header.resetVal)
+		return block
+	}
+
+	skip, err := strconv.Atoi(modifier[3])
+	if err != nil {
+		addWarning("", 0, "the leader modifier %v is not a number and has been ignored", modifier[0])
+		return block
+	}
+	for i := 0; i < skip; i++ {
+		iterateThisHeader(header)
+	}
+	return block
+}
+
 // isGoingDown analyzes the current leader and the next leader to determine
 // whether the tree is going up (returns true), down (returns false), or staying
 // at the same level (returns false. up the tree indicates that thisLeader (e.g.,
@@ -356,40 +542,49 @@ func iterateThisHeader(thisHeader *Header) {
 		return
 	}
 	switch thisHeader.style {
-	case 1, 2:
+	case 1, 2, 14:
 		thisHeader.currtVal = next_roman_upper(thisHeader.currtVal)
-	case 3, 4:
+	case 3, 4, 13:
 		thisHeader.currtVal = next_roman_lower(thisHeader.currtVal)
-	case 5, 6, 7, 8:
+	case 5, 6, 7, 8, 10, 11:
 		thisHeader.currtVal = next_lettering(thisHeader.currtVal)
-	case 9, 0:
+	case 9, 0, 12, 15, 21:
 		thisHeader.currtVal = next_numbering(thisHeader.currtVal)
+	case 16:
+		thisHeader.currtVal = next_padded_numbering(thisHeader.currtVal)
+	case 17:
+		thisHeader.currtVal = next_ordinal(thisHeader.currtVal)
+	case 18:
+		thisHeader.currtVal = next_doubled_lettering(thisHeader.currtVal)
 	}
 }
 
 // deIterateThisHeader is a helper function which is called by the preval parser and
 // simply calls helper functions from the util.go file depending on the style of
-// the current header.
+// the current header. Bullets and provisions without a leader are never iterated and so
+// are returned as they are.
 func deIterateThisHeader(thisHeader string, style int) string {
 	switch style {
-	case 1, 2:
+	case 1, 2, 14:
 		return prev_roman_upper(thisHeader)
-	case 3, 4:
+	case 3, 4, 13:
 		return prev_roman_lower(thisHeader)
-	case 5, 6, 7, 8:
+	case 5, 6, 7, 8, 10, 11:
 		return prev_lettering(thisHeader)
+	case 16:
+		return prev_padded_numbering(thisHeader)
+	case 17:
+		return prev_ordinal(thisHeader)
+	case 18:
+		return prev_doubled_lettering(thisHeader)
+	case 19, 20:
+		return thisHeader
 	default:
 		return prev_numbering(thisHeader)
 	}
 }
 
-// collateTheBlock replaces the cross references and joins up the block into a
-// single string
-func collateTheBlock(blockAsSlice []string, crossref map[string]string) string {
-	collatedBlock := strings.Join(blockAsSlice, "\n\n")
-	for pointer, replacer := range crossref {
-		pointer = "|" + pointer + "|"
-		collatedBlock = strings.Replace(collatedBlock, pointer, replacer, -1)
-	}
-	return collatedBlock
+// collateTheBlock joins up the block into a single string
+func collateTheBlock(blockAsSlice []string) string {
+	return strings.Join(blockAsSlice, "\n\n")
 }
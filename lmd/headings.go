@@ -0,0 +1,89 @@
+package lmd
+
+import (
+	"regexp"
+	"strings"
+)
+
+// headingPattern matches the heading of a provision, which is written in braces directly after the
+// leader (and after any cross reference stake), as in `ll. {Formation} The undersigned ...`. A
+// heading may not contain braces, so a mixin such as {{name}} is never taken for a heading.
+var headingPattern = regexp.MustCompile(`\A(\s*)\{([^{}\n]+)\}`)
+
+// headingStyles are the styles in which a heading may be set. Bold is the default.
+var headingStyles = map[string]bool{
+	"bold":       true,
+	"italic":     true,
+	"underline":  true,
+	"small-caps": true,
+	"none":       true,
+}
+
+// defaultHeadingStyle is the style used for the headings of levels which are not given one.
+const defaultHeadingStyle = "bold"
+
+// HeadingFormat is called to set the heading of each provision. Legalmarkdown sets headings with
+// markdown or, where markdown has no way of saying it, with html; renderers which style the text
+// themselves may set this function instead. It receives the heading, with any punctuation which
+// closes a run in heading, and the style of the level (bold, italic, underline, small-caps or none)
+// and returns the text to put in the document.
+var HeadingFormat func(heading string, style string) string
+
+// setTheHeadingStyles gives each level which has not been given a heading style in its structured
+// form the style of the "heading-style" parameter, or the default style if there is none.
+func setTheHeadingStyles(headers map[string]*Header, style string) {
+	style = strings.ToLower(strings.TrimSpace(style))
+	if style == "" {
+		style = defaultHeadingStyle
+	} else if !headingStyles[style] {
+		addWarning("", 0, "heading-style %q is not a known heading style and has been ignored", style)
+		style = defaultHeadingStyle
+	}
+	for _, header := range headers {
+		if header.heading == "" {
+			header.heading = style
+		}
+	}
+}
+
+// formatTheHeading sets the heading of a provision, which follows the newLeader in the block, in
+// the style of its level. A heading which runs in to the text of the provision is closed with a
+// period unless it already ends with punctuation, so `ll. {Formation} The undersigned` becomes
+// `Section 1. **Formation.** The undersigned`. A heading which stands on a line of its own, with
+// the text of the provision beginning on the next line, is left as it is written.
+func formatTheHeading(block string, header *Header, newLeader string) string {
+
+	if !strings.HasPrefix(block, newLeader) || !headingPattern.MatchString(block[len(newLeader):]) {
+		return block
+	}
+	rest := block[len(newLeader):]
+	match := headingPattern.FindStringSubmatch(rest)
+	rest = rest[len(match[0]):]
+
+	heading := strings.TrimSpace(match[2])
+	if rest != "" && !strings.HasPrefix(rest, "\n") && !strings.ContainsAny(heading[len(heading)-1:], ".:;?!") {
+		heading = heading + "."
+	}
+
+	return newLeader + match[1] + styleTheHeading(heading, header.heading) + rest
+}
+
+// styleTheHeading returns the heading marked up in the given style, or as HeadingFormat returns it
+// if that has been set.
+func styleTheHeading(heading string, style string) string {
+	if HeadingFormat != nil {
+		return HeadingFormat(heading, style)
+	}
+	switch style {
+	case "none":
+		return heading
+	case "italic":
+		return "*" + heading + "*"
+	case "underline":
+		return "<u>" + heading + "</u>"
+	case "small-caps":
+		return "<span style=\"font-variant: small-caps\">" + heading + "</span>"
+	default:
+		return "**" + heading + "**"
+	}
+}
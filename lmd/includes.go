@@ -0,0 +1,274 @@
+package lmd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxIncludeDepth is the deepest that partials may be nested within other partials. It is
+// a guard against runaway templates rather than a limit anyone should reach in practice.
+const maxIncludeDepth = 16
+
+// LibraryPaths is the search path for template libraries which is consulted when a partial cannot
+// be found relative to the file which includes it. Each entry is either a directory, which is
+// searched for plain partial names, or a `name=directory` pair which registers the directory as
+// a namespace so that `@include name:path/to/partial` will be read from that directory. Entries
+// from the LMD_PATH environment variable are searched after these.
+var LibraryPaths []string
+
+// namespacePattern matches partials which are referenced through a template library namespace,
+// such as `std:boilerplate/notices`. Namespaces must be at least two characters long so that they
+// are not mistaken for drive letters.
+var namespacePattern = regexp.MustCompile(`\A([A-Za-z][A-Za-z0-9_-]+):(.+)\z`)
+
+// includePattern matches the trigger lines for partials. Both the `@include PARTIAL` form and
+// the `@import PARTIAL` form which is documented in the README are recognized.
+var includePattern = regexp.MustCompile(`(?m)^@(?:include|import) (.*?)$`)
+
+// importIncludedFiles handles importing files into the primary contents string. The includingFile
+// is the name of the file from which the fileContents were read; it is used to resolve partials
+// which are referenced with a relative path. If the contents were read from stdin then "-" should
+// be passed and relative partials will be resolved against the current working directory.
+//
+// Partials may themselves include other partials. The function will walk the tree of includes
+// until every `@include PARTIAL` or `@import PARTIAL` line has been replaced with the contents of
+// the partial. Those lines within an `@raw` region are left as they are, as the includes are
+// resolved before the raw regions are hidden by hideTheEscapes.
+//
+// A partial may begin with its own front matter. That front matter is stripped from the partial
+// before it is pasted into the document and its parameters are gathered into a map of defaults.
+// The complete string and the map of defaults from the partials will be returned to the calling
+// function, which should merge the defaults underneath the parameters of the primary template.
+func importIncludedFiles(fileContents string, includingFile string) (string, map[string]string) {
+	scanTheCrossReferences(fileContents, includingFile, 0)
+	scanTheDefinedTerms(fileContents, includingFile, 0)
+	return importIncludedFilesFrom(fileContents, includingFile, []string{includeChainName(includingFile)}, 0)
+}
+
+// importIncludedFilesFrom is the recursive worker for importIncludedFiles. The chain slice holds
+// the names of the files which are currently being included, from the primary template down to
+// the includingFile, and is used to detect include cycles and to report them to the user.
+//
+// The provisions of an included partial, or of the section of a partial which is included with
+// `@include PARTIAL#SECTION`, keep their levels relative to one another but are moved up or down
+// the tree to fit where they are included; see relevelThePartial. The placedLevel is the level at
+// which the fileContents were themselves placed when they are a partial, or 0, so that a partial
+// which is included ahead of the first provision of another partial is moved along with it.
+func importIncludedFilesFrom(fileContents string, includingFile string, chain []string, placedLevel int) (string, map[string]string) {
+
+	defaults := make(map[string]string)
+	if !includePattern.MatchString(fileContents) {
+		return fileContents, defaults
+	}
+
+	assembled := ""
+	lastIndex := 0
+	rawRegions := rawRegionPattern.FindAllStringIndex(fileContents, -1)
+	for _, match := range includePattern.FindAllStringSubmatchIndex(fileContents, -1) {
+
+		if isWithinARawRegion(match[0], rawRegions) {
+			continue
+		}
+
+		line := strings.Count(fileContents[:match[0]], "\n") + 1
+		target, options := splitIncludeOptions(fileContents[match[2]:match[3]], includingFile, line)
+		partial, section := splitIncludeTarget(target)
+		partialFile := resolveIncludedFile(partial, includingFile)
+		partialName := includeChainName(partialFile)
+
+		// guard against a partial which includes itself, whether directly or further down the tree.
+		for _, included := range chain {
+			if included == partialName {
+				log.Fatalf("Include cycle detected: %v", strings.Join(append(chain, partialName), " -> "))
+			}
+		}
+
+		if len(chain) > maxIncludeDepth {
+			log.Fatalf("Includes are nested more than %v levels deep: %v", maxIncludeDepth, strings.Join(append(chain, partialName), " -> "))
+		}
+
+		// pull the front matter off of the partial and cut out the requested section, if any.
+		frontMatter, partialContents := parseTemplateToFindParameters(ReadAFile(partialFile))
+		firstLine := 0
+		if frontMatter != "" {
+			firstLine = strings.Count(frontMatter, "\n") + 1
+		}
+		if section != "" {
+			var sectionLine int
+			partialContents, sectionLine = selectTheSection(partialContents, section, partialFile)
+			firstLine = firstLine + sectionLine
+		}
+		scanTheCrossReferences(partialContents, partialFile, firstLine)
+		scanTheDefinedTerms(partialContents, partialFile, firstLine)
+		if section != "" {
+			partialContents = stripTheSectionFences(partialContents)
+		}
+		precedingLevel := levelOfThePrecedingLeader(fileContents[:match[0]], placedLevel)
+		partialContents, partialLevel := relevelThePartial(partialContents, options["level"], precedingLevel, includingFile, line)
+
+		// the partial's own parameters take precedence over the defaults of any partials which it
+		// includes in turn.
+		partialContents, partialDefaults := importIncludedFilesFrom(partialContents, partialFile, append(chain[:len(chain):len(chain)], partialName), partialLevel)
+		partialParameters := mergeDefaultParameters(unmarshallParameters(frontMatter), partialDefaults)
+
+		// where two partials included by the same file disagree the first one wins, so the front
+		// matter of this one is not used and whoever wrote it should know.
+		for key, val := range partialParameters {
+			if earlier, exists := defaults[key]; exists && earlier != val {
+				addWarning(includingFile, line, "parameter %q is %q in the front matter of %v but %q in the front matter of a partial included before it, which is used", key, val, partial, earlier)
+			}
+		}
+		defaults = mergeDefaultParameters(defaults, partialParameters)
+
+		// the include line keeps its own new line, so the partial's final new lines are taken off
+		// and one is only put back to end the paragraph when text follows without a blank line.
+		partialContents = strings.TrimRight(partialContents, "\n")
+		if following := fileContents[match[1]:]; strings.TrimLeft(following, "\n") != "" && !strings.HasPrefix(following, "\n\n") {
+			partialContents = partialContents + "\n"
+		}
+
+		assembled = assembled + fileContents[lastIndex:match[0]] + partialContents
+		lastIndex = match[1]
+	}
+	assembled = assembled + fileContents[lastIndex:]
+
+	return assembled, defaults
+}
+
+// mergeDefaultParameters merges the defaults map underneath the parameters map, so that where both
+// maps hold the same key the value in the parameters map is kept.
+func mergeDefaultParameters(parameters map[string]string, defaults map[string]string) map[string]string {
+	for key, val := range defaults {
+		if _, exists := parameters[key]; !exists {
+			parameters[key] = val
+		}
+	}
+	return parameters
+}
+
+// splitIncludeOptions separates the options at the end of an include line, such as the `level=+2`
+// in `@include partial.lmd level=+2`, from the partial which is to be included. Options which are
+// not understood are reported as warnings and otherwise ignored.
+func splitIncludeOptions(target string, includingFile string, line int) (string, map[string]string) {
+
+	options := make(map[string]string)
+	optionPattern := regexp.MustCompile(`\A([a-z]+)=(\S+)\z`)
+
+	fields := strings.Fields(target)
+	for len(fields) > 1 && optionPattern.MatchString(fields[len(fields)-1]) {
+		option := optionPattern.FindStringSubmatch(fields[len(fields)-1])
+		if option[1] == "level" {
+			options[option[1]] = option[2]
+		} else {
+			addWarning(includingFile, line, "the include option %v is not recognized and has been ignored", option[0])
+		}
+		fields = fields[:len(fields)-1]
+	}
+
+	return strings.Join(fields, " "), options
+}
+
+// resolveIncludedFile turns the partial named on an include line into a filename which can be read.
+// Absolute paths are used as is. Relative paths are resolved against the directory of the file
+// which includes them. For compatibility with templates written when partials were resolved against
+// the current working directory, that location is used if the partial does not exist alongside the
+// including file.
+//
+// If the partial is still not found, the template library search path is consulted. Partials
+// referenced with a namespace are only ever read from the template library.
+func resolveIncludedFile(partial string, includingFile string) string {
+
+	if namespacePattern.MatchString(partial) {
+		namespaced := namespacePattern.FindStringSubmatch(partial)
+		if libraryFile, found := findInLibrary(namespaced[1], namespaced[2]); found {
+			return libraryFile
+		}
+		log.Fatalf("Could not find %v in the template library. Searched: %v", partial, strings.Join(libraryPaths(), string(os.PathListSeparator)))
+	}
+
+	if filepath.IsAbs(partial) {
+		return partial
+	}
+
+	relativeToIncluder := partial
+	if !(includingFile == "" || includingFile == "-" || includingFile == " -") {
+		relativeToIncluder = filepath.Join(filepath.Dir(includingFile), partial)
+	}
+	if _, err := os.Stat(relativeToIncluder); err == nil {
+		return relativeToIncluder
+	}
+
+	if _, err := os.Stat(partial); err == nil {
+		return partial
+	}
+
+	if libraryFile, found := findInLibrary("", partial); found {
+		return libraryFile
+	}
+
+	return relativeToIncluder
+}
+
+// findInLibrary searches the template library for a partial. When a namespace is given only the
+// directories registered to that namespace are searched, otherwise only the directories without
+// a namespace are searched. The partial may be named with or without its `.lmd` extension.
+func findInLibrary(namespace string, partial string) (string, bool) {
+	for _, entry := range libraryPaths() {
+		entryNamespace, dir := splitLibraryEntry(entry)
+		if entryNamespace != namespace {
+			continue
+		}
+		for _, candidate := range []string{partial, partial + ".lmd"} {
+			candidate = filepath.Join(dir, candidate)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate, true
+			}
+		}
+	}
+	return "", false
+}
+
+// libraryPaths assembles the template library search path from the LibraryPaths variable and the
+// LMD_PATH environment variable, in that order.
+func libraryPaths() []string {
+	paths := append([]string{}, LibraryPaths...)
+	return append(paths, SplitLibraryPath(os.Getenv("LMD_PATH"))...)
+}
+
+// SplitLibraryPath splits a list of template library entries which are separated in the same way
+// as the PATH environment variable (by colons, or semicolons on windows) into a slice which may be
+// added to LibraryPaths.
+func SplitLibraryPath(paths string) []string {
+	entries := []string{}
+	for _, entry := range filepath.SplitList(paths) {
+		if strings.TrimSpace(entry) != "" {
+			entries = append(entries, strings.TrimSpace(entry))
+		}
+	}
+	return entries
+}
+
+// splitLibraryEntry separates a `name=directory` template library entry into its namespace and its
+// directory. Entries without a namespace return an empty namespace.
+func splitLibraryEntry(entry string) (string, string) {
+	if i := strings.Index(entry, "="); i > 0 {
+		return entry[:i], entry[i+1:]
+	}
+	return "", entry
+}
+
+// includeChainName is a convenience function which returns the name used to identify a file in the
+// chain of includes. Absolute paths are used where they can be determined so that the same partial
+// reached by two different relative paths is still recognized as the same file.
+func includeChainName(file string) string {
+	if file == "" || file == "-" || file == " -" {
+		return "-"
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
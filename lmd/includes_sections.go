@@ -0,0 +1,223 @@
+package lmd
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sectionFenceOpen and sectionFenceClose match the named fences which can be used in a clause
+// library to mark a region of a partial that may be included on its own:
+//
+//	::: confidentiality
+//	...
+//	:::
+//
+// The fence lines of a section which is included on its own never make it into the output. A
+// partial which is included whole is left as it is, so fences which are written for some other
+// purpose, such as the `::: warning` divs of an admonition, are kept.
+var sectionFenceOpen = regexp.MustCompile(`\A:{3,}\s*([^\s:]+)\s*\z`)
+var sectionFenceClose = regexp.MustCompile(`\A:{3,}\s*\z`)
+
+// leaderPatternOld and leaderPatternNew match the structured header leaders ("llll." and "l4.")
+// at the beginning of a line.
+var leaderPatternOld = regexp.MustCompile(`\Al+\.`)
+var leaderPatternNew = regexp.MustCompile(`\Al[0-9]+\.`)
+
+// leaderModifierPattern matches a leader which is followed by a manual numbering modifier, such as
+// `ll.=14` or `l2.+2`; see applyTheLeaderModifier.
+var leaderModifierPattern = regexp.MustCompile(`\A(l+\.|l[0-9]+\.)([=+])(\S+)`)
+
+// blockFencePattern matches the three backticks which open or close a structured headers block.
+var blockFencePattern = regexp.MustCompile("\\A```")
+
+// splitIncludeTarget separates the partial named on an include line from the name of the section
+// which is to be included, as in `@include clauses.lmd#confidentiality`. If no section is named the
+// section returned is an empty string.
+func splitIncludeTarget(target string) (string, string) {
+	if i := strings.LastIndex(target, "#"); i > 0 {
+		return strings.TrimSpace(target[:i]), strings.TrimSpace(target[i+1:])
+	}
+	return target, ""
+}
+
+// selectTheSection pulls a single section out of the contents of a partial. A section is either
+// a region between named fences (`::: name` ... `:::`) or a provision which has been staked with a
+// cross reference (`ll. |name| ...`) along with all of the provisions beneath it in the tree.
+// The index of the first line of the section within the contents is returned along with it.
+func selectTheSection(contents string, section string, partialFile string) (string, int) {
+
+	lines := strings.Split(contents, "\n")
+	stakePattern := regexp.MustCompile(`\A(l+|l[0-9]+)\.(?:[=+]\S+)? *\|` + regexp.QuoteMeta(section) + `\|`)
+
+	for i, line := range lines {
+
+		// a named fence runs to its matching closing fence; fences may be nested.
+		if sectionFenceOpen.MatchString(line) && sectionFenceOpen.FindStringSubmatch(line)[1] == section {
+			depth := 1
+			for j := i + 1; j < len(lines); j++ {
+				if sectionFenceOpen.MatchString(lines[j]) {
+					depth++
+				} else if sectionFenceClose.MatchString(lines[j]) {
+					depth--
+				}
+				if depth == 0 {
+					return strings.Join(lines[i+1:j], "\n"), i + 1
+				}
+			}
+			log.Fatalf("The section %q in %v is never closed with a ::: line.", section, partialFile)
+		}
+
+		// a staked provision runs until the next provision at the same level or higher in the tree.
+		if stakePattern.MatchString(line) {
+			level := leaderLevel(leaderOfTheLine(line))
+			j := i + 1
+			for ; j < len(lines); j++ {
+				if blockFencePattern.MatchString(lines[j]) || sectionFenceOpen.MatchString(lines[j]) || sectionFenceClose.MatchString(lines[j]) {
+					break
+				}
+				if leader := leaderOfTheLine(lines[j]); leader != "" && leaderLevel(leader) <= level {
+					break
+				}
+			}
+			return strings.TrimRight(strings.Join(lines[i:j], "\n"), "\n") + "\n", i
+		}
+	}
+
+	log.Fatalf("Could not find the section %q in %v.", section, partialFile)
+	return "", 0
+}
+
+// relevelThePartial moves the provisions of an included partial up or down the tree. If the
+// include line carried a `level=` option then that option controls the move: `level=+2` or
+// `level=-1` moves every provision by that many levels, and `level=3` moves the provisions so
+// that the top provision of the partial sits at level 3. Without the option the partial is
+// moved so that its top provision sits at the same level as the provision which precedes the
+// include line (see levelOfThePrecedingLeader); partials included outside of a structured headers
+// block are left as they are.
+//
+// The level at which the top provision of the partial now sits is returned along with it, or 0 if
+// the partial was left where it was. A partial without any provisions passes on the level of the
+// provision which precedes it. This is the level which any includes within the partial, ahead of
+// its own first provision, follow in turn.
+func relevelThePartial(contents string, levelOption string, precedingLevel int, includingFile string, line int) (string, int) {
+
+	topLevel := topLevelOfTheLeaders(contents)
+	if topLevel == 0 {
+		return contents, precedingLevel
+	}
+
+	if levelOption != "" {
+		shift, err := strconv.Atoi(levelOption)
+		if err != nil {
+			addWarning(includingFile, line, "the include option level=%v is not a number and has been ignored", levelOption)
+		} else {
+			if !(strings.HasPrefix(levelOption, "+") || strings.HasPrefix(levelOption, "-")) {
+				shift = shift - topLevel
+			}
+			contents = relevelTheLeaders(contents, shift)
+			return contents, topLevelOfTheLeaders(contents)
+		}
+	}
+
+	if precedingLevel != 0 {
+		return relevelTheLeaders(contents, precedingLevel-topLevel), precedingLevel
+	}
+
+	return contents, 0
+}
+
+// stripTheSectionFences removes the fence lines of any sections nested within a section which is
+// included on its own, so that they do not leak into the output.
+func stripTheSectionFences(contents string) string {
+	lines := []string{}
+	for _, line := range strings.Split(contents, "\n") {
+		if sectionFenceOpen.MatchString(line) || sectionFenceClose.MatchString(line) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// levelOfThePrecedingLeader looks backwards from the end of the contents for the provision
+// which an included partial will follow. If it finds one within the current structured headers
+// block it returns that provision's level, and if it finds the beginning of the block first it
+// returns 0. If it finds neither then the contents are themselves a partial which has been
+// included ahead of any provision of its own, and the level which that partial was placed at is
+// returned.
+func levelOfThePrecedingLeader(contents string, placedLevel int) int {
+	lines := strings.Split(contents, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if blockFencePattern.MatchString(lines[i]) {
+			return 0
+		}
+		if leader := leaderOfTheLine(lines[i]); leader != "" {
+			return leaderLevel(leader)
+		}
+	}
+	return placedLevel
+}
+
+// topLevelOfTheLeaders returns the highest level in the tree (the lowest number) used by any of
+// the provisions in the contents, or 0 if there are no provisions.
+func topLevelOfTheLeaders(contents string) int {
+	top := 0
+	for _, line := range strings.Split(contents, "\n") {
+		if leader := leaderOfTheLine(line); leader != "" {
+			if level := leaderLevel(leader); top == 0 || level < top {
+				top = level
+			}
+		}
+	}
+	return top
+}
+
+// relevelTheLeaders moves every provision in the contents up or down the tree by shift levels,
+// rewriting both old style ("llll.") and new style ("l4.") leaders in their own style. No
+// provision is moved above level 1.
+func relevelTheLeaders(contents string, shift int) string {
+	if shift == 0 {
+		return contents
+	}
+	lines := strings.Split(contents, "\n")
+	for i, line := range lines {
+		leader := leaderOfTheLine(line)
+		if leader == "" {
+			continue
+		}
+		level := leaderLevel(leader) + shift
+		if level < 1 {
+			level = 1
+		}
+		if leaderPatternNew.MatchString(leader) {
+			lines[i] = "l" + strconv.Itoa(level) + "." + line[len(leader):]
+		} else {
+			lines[i] = strings.Repeat("l", level) + "." + line[len(leader):]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// leaderOfTheLine returns the structured header leader at the beginning of the line, or an empty
+// string if the line does not begin with one.
+func leaderOfTheLine(line string) string {
+	if leaderPatternNew.MatchString(line) {
+		return leaderPatternNew.FindString(line)
+	}
+	if leaderPatternOld.MatchString(line) {
+		return leaderPatternOld.FindString(line)
+	}
+	return ""
+}
+
+// leaderLevel returns the level in the tree of a leader in either style, so "lll." and "l3."
+// both return 3.
+func leaderLevel(leader string) int {
+	if leaderPatternNew.MatchString(leader) {
+		level, _ := strconv.Atoi(leader[1 : len(leader)-1])
+		return level
+	}
+	return len(leader) - 1
+}
@@ -29,22 +29,34 @@ package lmd
 // back to the user.
 func LegalToMarkdown(contentsFile string, parametersFile string, outputFile string) {
 
+	// in strict mode parameters which do not match the schema stop the job before it is parsed.
 	contents, parameters := setUp(contentsFile, parametersFile)
+	stopIfStrict()
 	contents, parameters = HandleMixins(contents, parameters)
 
 	headers := SetTheHeaders(contents, parameters)
 	contents = HandleTheHeaders(contents, headers)
 
+	stopIfStrict()
 	writeAFile(outputFile, contents)
+	reportTheDiagnostics()
 }
 
 // MakeYAMLFrontMatter is a convenience function which will parse the contents of a template
-// to formulate the YAML Front Matter.
+// to formulate the YAML Front Matter. If the template already has front matter then it is
+// updated in place (see updateTheFrontMatter) rather than being built afresh.
 func MakeYAMLFrontMatter(contentsFile string, parametersFile string, outputFile string) {
 
-	contents, parameters := setUp(contentsFile, parametersFile)
-	contents = HandleParameterAssembly(contents, parameters)
+	contents, frontMatter, parameters := readTheTemplate(contentsFile, parametersFile)
+	parameters = validateTheParameters(parameters, readTheSchema(parameters, contentsFile), contentsFile, parametersFile)
+
+	if frontMatter == "" {
+		contents = HandleParameterAssembly(contents, parameters)
+	} else {
+		contents = updateTheFrontMatter(frontMatter, contents, parameters)
+	}
 	writeAFile(outputFile, contents)
+	reportTheDiagnostics()
 
 }
 
@@ -58,13 +70,30 @@ func MakeYAMLFrontMatter(contentsFile string, parametersFile string, outputFile
 func MarkdownToPDF(contentsFile string, parametersFile string, outputFile string) {
 
 	contents, parameters := setUp(contentsFile, parametersFile)
+	stopIfStrict()
 	contents, parameters = HandleMixins(contents, parameters)
 
 	headers := SetTheHeaders(contents, parameters)
 	contents = HandleTheHeaders(contents, headers)
 
+	stopIfStrict()
 	WriteToPdf(contents, outputFile)
+	reportTheDiagnostics()
+
+}
+
+// Lint runs a template through the normal parsing system without writing any output and
+// returns the diagnostics which were collected along the way, such as conflicting defaults
+// in the front matter of included partials.
+func Lint(contentsFile string, parametersFile string) []Diagnostic {
+
+	contents, parameters := setUp(contentsFile, parametersFile)
+	contents, parameters = HandleMixins(contents, parameters)
+
+	headers := SetTheHeaders(contents, parameters)
+	HandleTheHeaders(contents, headers)
 
+	return diagnostics
 }
 
 // GetTheParameters is a wrapper function which enables a system to determine what
@@ -80,7 +109,9 @@ func MarkdownToPDF(contentsFile string, parametersFile string, outputFile string
 // function.
 func GetTheParameters(contentsFile string) string {
 
+	// the schema describes the parameters rather than being one of them.
 	_, parameters := setUp(contentsFile, "")
+	parameters = removeTheSchema(parameters)
 
 	if len(parameters) == 0 {
 		contents, _ := setUp(contentsFile, "")
@@ -90,17 +121,35 @@ func GetTheParameters(contentsFile string) string {
 	}
 }
 
+// GetTheParameterDependencies is a wrapper function which enables a system to determine which of
+// the parameters of a given template file are only used within optional clauses, so that it need
+// only ask for them once those clauses have been turned on. It returns a JSON object which maps
+// each optional clause to the parameters (and nested clauses) which are only used directly within
+// it (see dependencyTree):
+//
+//	{"is_three_party": ["party3", "party3_guarantee"], "party3_guarantee": ["party3_guarantor"]}
+//
+// GetTheParameters gives the same tree, as a JSON string under the reserved "@dependencies" key,
+// so that its parameters keep to a flat object of strings.
+func GetTheParameterDependencies(contentsFile string) string {
+
+	contents, _ := setUp(contentsFile, "")
+	return AssembleDependenciesIntoJSON(contents)
+}
+
 // RawMarkdownToPDF is a function which does not work with written or written_to files
 // instead of dealing with files to be read, the function simply parses strings which are
 // passed to it programmatically. Otherwise the function logic mirrors MarkdownToPDF.
 func RawMarkdownToPDF(rawContents string, rawParameters string) string {
 
 	contents, parameters := setUpRaw(rawContents, rawParameters)
+	stopIfStrict()
 	contents, parameters = HandleMixins(contents, parameters)
 
 	headers := SetTheHeaders(contents, parameters)
 	contents = HandleTheHeaders(contents, headers)
 
+	stopIfStrict()
 	return WriteToPdfRaw(contents)
 
 }
@@ -113,21 +162,46 @@ func RawMarkdownToPDF(rawContents string, rawParameters string) string {
 // If a paramaters file is sent to the function, then that file will also be unmarshalled
 // and any paramaters which are contained in both the template file and the parameters file
 // will be overwritten in favor of the values included in the parameters file.
+//
+// Next any parameters from the front matter of included partials are used as defaults for
+// those parameters which neither the template file nor the parameters file have set. All of this
+// is done by readTheTemplate.
+//
+// Lastly, if the template has a schema (see readTheSchema), the defaults it gives are filled in
+// and every parameter it describes is checked against it, so that all of the problems with the
+// parameters are reported together before the template is parsed.
 func setUp(contentsFile string, parametersFile string) (string, map[string]string) {
 
+	contents, _, parameters := readTheTemplate(contentsFile, parametersFile)
+
+	// fill in the defaults of the schema, if the template has one, and check the parameters against it.
+	parameters = validateTheParameters(parameters, readTheSchema(parameters, contentsFile), contentsFile, parametersFile)
+
+	return contents, parameters
+}
+
+// readTheTemplate reads the template file, its partials and the parameters for setUp, without
+// checking the parameters against the schema. The front matter of the template is returned as it
+// was written, so that it may be updated in place by the assemble command.
+func readTheTemplate(contentsFile string, parametersFile string) (string, string, map[string]string) {
+
+	// start the parse job without any diagnostics left over from a previous job
+	resetTheDiagnostics()
+
 	// read the template file and integrate any included partials (`@include PARTIAL` within the text)
 	contents := ReadAFile(contentsFile)
-	contents = importIncludedFiles(contents)
+	contents, partialDefaults := importIncludedFiles(contents, contentsFile)
 
 	// once the content files have been read, then move along to parsing the parameters.
 	var parameters string
+	var frontMatter string
 	var amendedParameters map[string]string
 	if parametersFile != "" {
 
 		// first pull out of the file, just as we do if there is no specific params file
 		var mergedParameters map[string]string
-		parameters, contents = parseTemplateToFindParameters(contents)
-		mergedParameters = unmarshallParameters(parameters)
+		frontMatter, contents = parseTemplateToFindParameters(contents)
+		mergedParameters = unmarshallParameters(frontMatter)
 
 		// second read and unmarshall the parameters from the parameters file
 		parameters = ReadAFile(parametersFile)
@@ -141,15 +215,24 @@ func setUp(contentsFile string, parametersFile string) (string, map[string]strin
 	} else {
 
 		// if there is no parameters file passed, simply pull the params out of the content file.
-		parameters, contents = parseTemplateToFindParameters(contents)
-		amendedParameters = unmarshallParameters(parameters)
+		frontMatter, contents = parseTemplateToFindParameters(contents)
+		amendedParameters = unmarshallParameters(frontMatter)
 
 	}
-	return contents, amendedParameters
+
+	// any front matter from the partials only fills in parameters which have not been set.
+	amendedParameters = mergeDefaultParameters(amendedParameters, partialDefaults)
+
+	return contents, frontMatter, amendedParameters
 }
 
 func setUpRaw(contents string, rawParameters string) (string, map[string]string) {
 
+	// start the parse job without any diagnostics left over from a previous job
+	resetTheDiagnostics()
+	scanTheCrossReferences(contents, "-", 0)
+	scanTheDefinedTerms(contents, "-", 0)
+
 	// once the content files have been read, then move along to parsing the parameters.
 	var parameters string
 	var amendedParameters map[string]string
@@ -175,5 +258,9 @@ func setUpRaw(contents string, rawParameters string) (string, map[string]string)
 		amendedParameters = unmarshallParameters(parameters)
 
 	}
+
+	// fill in the defaults of the schema, if the front matter has one, and check the parameters against it.
+	amendedParameters = validateTheParameters(amendedParameters, readTheSchema(amendedParameters, ""), "-", "")
+
 	return contents, amendedParameters
 }
\ No newline at end of file
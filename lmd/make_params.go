@@ -5,7 +5,9 @@ import (
 	"gopkg.in/yaml.v2"
 	"log"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 // HandleParameterAssembly is the primary parsing function which is used by the cli
@@ -16,13 +18,18 @@ import (
 //
 // First the function will establish four maps, one each for the mixins, the optional
 // clauses, the headers, and they style parameters. Then the function will run through
-// searching functions to handle the assembly of each of these four major elements.
+// searching functions to handle the assembly of each of these four major elements. Any text
+// which the template has escaped is hidden from the searches.
 //
 // If any parameters have been sent to the function via the paramaters map, the values
 // in each of the parameters field will be maintained. If the keys in the parameters
 // map do not exist, but the appropriate mixin, optional clause, or header exists in the
 // text of the template, then the appropriate key will be added to the appropriate map.
 //
+// Each mixin or optional clause which is only used within other optional clauses is marked with a
+// comment naming those clauses (see findTheDependencies), so that whoever fills in the front matter
+// knows which parameters they may leave alone when a clause is turned off.
+//
 // Lastly the function will call a function that will reassemble the file by running
 // through each of the maps and building the front matter. The reassembled contents
 // are returned to the calling function.
@@ -33,16 +40,24 @@ func HandleParameterAssembly(contents string, parameters map[string]string) stri
 	headers := make(map[string]string)
 	styles := make(map[string]string)
 
-	contents, mixins = findTheMixins(contents, parameters)
-	contents, optClauses = findTheOptClauses(contents, parameters)
-	contents, headers, styles = findTheLeaders(contents, parameters)
+	searchable := hideTheEscapes(contents)
+	_, mixins = findTheMixins(searchable, parameters)
+	_, optClauses = findTheOptClauses(searchable, parameters)
+	_, headers, styles = findTheLeaders(searchable, parameters)
+
+	dependencies := findTheDependencies(searchable)
+	order := findTheParameterOrder(searchable)
 
-	contents = reAssembleTheFile(contents, mixins, optClauses, headers, styles)
+	contents = reAssembleTheFile(contents, mixins, optClauses, headers, styles, findTheSchema(parameters), dependencies, order)
 
 	return contents
 
 }
 
+// dependenciesKey is the reserved key under which AssembleParametersIntoJSON gives the tree of the
+// parameters which are only used within optional clauses.
+const dependenciesKey = "@dependencies"
+
 // HandleParameterAssemblyJSON performs roughly the same parsing function as the
 // HandleParamaterAssembly function.
 //
@@ -50,6 +65,13 @@ func HandleParameterAssembly(contents string, parameters map[string]string) stri
 // then each of the keys and values in these maps are copied back into the parameters
 // map.
 //
+// If any of the parameters are only used within optional clauses then the tree of those
+// dependencies (see AssembleDependenciesIntoJSON) is added under the reserved "@dependencies"
+// key. Its value is the tree as a JSON string, so that the parameters remain a flat object of
+// strings for the systems which read them into a map of strings:
+//
+//	"@dependencies": "{\"is_three_party\":[\"party3\",\"party3_guarantee\"]}"
+//
 // Finally the reassembled parameters map is marshaled into a JSON string that is
 // returned to the calling function.
 func AssembleParametersIntoJSON(contents string, parameters map[string]string) string {
@@ -59,9 +81,10 @@ func AssembleParametersIntoJSON(contents string, parameters map[string]string) s
 	headers := make(map[string]string)
 	styles := make(map[string]string)
 
-	contents, mixins = findTheMixins(contents, parameters)
-	contents, optClauses = findTheOptClauses(contents, parameters)
-	contents, headers, styles = findTheLeaders(contents, parameters)
+	searchable := hideTheEscapes(contents)
+	_, mixins = findTheMixins(searchable, parameters)
+	_, optClauses = findTheOptClauses(searchable, parameters)
+	_, headers, styles = findTheLeaders(searchable, parameters)
 
 	for k, v := range mixins {
 		parameters[k] = v
@@ -75,6 +98,9 @@ func AssembleParametersIntoJSON(contents string, parameters map[string]string) s
 	for k, v := range styles {
 		parameters[k] = v
 	}
+	if len(findTheDependencies(searchable)) != 0 {
+		parameters[dependenciesKey] = AssembleDependenciesIntoJSON(contents)
+	}
 
 	paramsAsJsonByteArray, err := json.Marshal(parameters)
 
@@ -86,6 +112,23 @@ func AssembleParametersIntoJSON(contents string, parameters map[string]string) s
 
 }
 
+// AssembleDependenciesIntoJSON finds the parameters of the contents which are only used within
+// optional clauses and marshals the tree of those dependencies (see dependencyTree) into a JSON
+// string that is returned to the calling function.
+func AssembleDependenciesIntoJSON(contents string) string {
+
+	dependencies := dependencyTree(findTheDependencies(hideTheEscapes(contents)))
+
+	dependenciesAsJsonByteArray, err := json.Marshal(dependencies)
+
+	if err != nil {
+		log.Fatal("JSON assembly error.")
+	}
+
+	return string(dependenciesAsJsonByteArray)
+
+}
+
 // findTheMixins runs through the text of the content to find mixin patterns.
 // These are then placed into the mixins map, which is rationalized against the
 // parameters map so that the values which are passed from the parameters map
@@ -135,7 +178,8 @@ func findTheOptClauses(contents string, parameters map[string]string) (string, m
 //
 // After checking whether the header style is oldStyle ("llll.") or newStyle ("l4.") then the
 // function loops through the slice and for each of the leaders it sinks these into the headers
-// map which also checking if the value from the parameters map is kept.
+// map which also checking if the value from the parameters map is kept. The fields of levels
+// which are defined in their structured form are kept as well.
 //
 // Finally the function assembles a three length map for the styles by performing roughly the
 // same algorithm as the rest of this file to ensure that the values of the parameters map are maintained.
@@ -165,10 +209,17 @@ func findTheLeaders(contents string, parameters map[string]string) (string, map[
 			leader = string(leader[1])
 			leader = "level-" + leader
 		}
-		if _, exists := parameters[leader]; !exists {
-			headers[leader] = ""
-		} else {
+		if _, exists := parameters[leader]; exists {
 			headers[leader] = parameters[leader]
+		} else if !hasALevelDefinition(leader, parameters) {
+			headers[leader] = ""
+		}
+	}
+
+	// keep the fields of any levels which are defined in their structured form.
+	for key, val := range parameters {
+		if levelDefinitionPattern.MatchString(key) {
+			headers[key] = val
 		}
 	}
 
@@ -178,9 +229,25 @@ func findTheLeaders(contents string, parameters map[string]string) (string, map[
 		styles = assembleStyle(style, parameters, styles)
 	}
 
+	// the heading style is only kept if the template sets one.
+	if headingStyle, exists := parameters["heading-style"]; exists {
+		styles["heading-style"] = headingStyle
+	}
+
 	return contents, headers, styles
 }
 
+// hasALevelDefinition returns true if any of the fields of the structured form of the level are
+// among the parameters.
+func hasALevelDefinition(level string, parameters map[string]string) bool {
+	for key := range parameters {
+		if strings.HasPrefix(key, level+".") {
+			return true
+		}
+	}
+	return false
+}
+
 // assembleStyle is a convenience function which simply checks if the passed style
 // parameter is already in the parameters map and if so, it sinks that value into the
 // style map and returns the map.
@@ -196,43 +263,130 @@ func assembleStyle(style string, parameters map[string]string, styles map[string
 // reAssembleTheFile is a convenience function which builds the front matter in a particular way
 // which will make it easy for users of the legalmarkdown system to understand how to use the system.
 //
-// It first will build the mixins, then the Optional Clauses, then the headers, and finally the styles
-// along the way if any of these blocks does not exist then the appropriate section will not be built.
+// It first will build the mixins, then the Optional Clauses, then the headers, then the styles and
+// finally the schema, if the front matter had one. along the way if any of these blocks does not
+// exist then the appropriate section will not be built.
+//
+// The mixins and optional clauses are written in the order in which they first appear in the
+// template, and those which depend on other optional clauses are followed by a comment saying so
+// (see annotateTheDependencies).
 //
 // Before any of that building, the function will check to make sure whether all of the main maps are
 // empty (in which case there is no front matter to build and the content without front matter is
 // returned to the calling function).
-func reAssembleTheFile(contents string, mixins map[string]string, optClauses map[string]string, headers map[string]string, styles map[string]string) string {
+func reAssembleTheFile(contents string, mixins map[string]string, optClauses map[string]string, headers map[string]string, styles map[string]string, schema map[string]map[string]interface{}, dependencies map[string][]string, order []string) string {
 
 	if !(len(mixins) == 0) || !(len(optClauses) == 0) || !(len(headers) == 0) {
 		frontMatter := "---\n\n"
 		if !(len(mixins) == 0) {
-			mixinsAsByteArray, _ := yaml.Marshal(mixins)
-			mixinString := string(mixinsAsByteArray)
+			mixinsAsByteArray, _ := yaml.Marshal(orderTheSection(mixins, order))
+			mixinString := annotateTheDependencies(string(mixinsAsByteArray), mixins, dependencies)
 			frontMatter = frontMatter + "# Mixins\n" + mixinString
 		}
 		if !(len(mixins) == 0) && !(len(optClauses) == 0) {
 			frontMatter = frontMatter + "\n"
 		}
 		if !(len(optClauses) == 0) {
-			optClausesAsByteArray, _ := yaml.Marshal(optClauses)
-			optClausesString := string(optClausesAsByteArray)
+			optClausesAsByteArray, _ := yaml.Marshal(orderTheSection(optClauses, order))
+			optClausesString := annotateTheDependencies(string(optClausesAsByteArray), optClauses, dependencies)
 			frontMatter = frontMatter + "# Optional Clauses\n" + optClausesString
 		}
 		if (!(len(mixins) == 0) || !(len(optClauses) == 0)) && !(len(headers) == 0) {
 			frontMatter = frontMatter + "\n"
 		}
 		if !(len(headers) == 0) {
-			headersAsByteArray, _ := yaml.Marshal(headers)
+			headersAsByteArray, _ := yaml.Marshal(nestTheParameters(headers))
 			headersString := string(headersAsByteArray)
 			stylesAsByteArray, _ := yaml.Marshal(styles)
 			stylesString := string(stylesAsByteArray)
 			frontMatter = frontMatter + "# Structured Headers\n" + headersString
 			frontMatter = frontMatter + "\n# Properties\n" + stylesString
 		}
+		if !(len(schema) == 0) {
+			schemaAsByteArray, _ := yaml.Marshal(map[string]interface{}{"schema": schema})
+			schemaString := string(schemaAsByteArray)
+			frontMatter = frontMatter + "\n# Schema\n" + schemaString
+		}
 		frontMatter = frontMatter + "\n---\n\n"
 		contents = frontMatter + contents
 	}
 
 	return contents
 }
+
+// findTheSchema gathers the schema which was given in the front matter back into the nested form
+// in which it was written, so that it is kept when the front matter is reassembled. The allowed
+// values of a parameter are written as a list and whether it is required as true or false.
+func findTheSchema(parameters map[string]string) map[string]map[string]interface{} {
+	schema := make(map[string]map[string]interface{})
+	for key, val := range parameters {
+		i := strings.LastIndex(key, ".")
+		if !strings.HasPrefix(key, schemaPrefix) || i < len(schemaPrefix) {
+			continue
+		}
+		name, field := key[len(schemaPrefix):i], key[i+1:]
+		if schema[name] == nil {
+			schema[name] = make(map[string]interface{})
+		}
+		if field == "allowed" {
+			schema[name][field] = strings.Split(val, schemaListSeparator)
+		} else if required, err := strconv.ParseBool(val); field == "required" && err == nil {
+			schema[name][field] = required
+		} else {
+			schema[name][field] = val
+		}
+	}
+	return schema
+}
+
+// orderTheSection puts the parameters of a section of the front matter in the order given, so that
+// they are not written in the alphabetical order of a map. Any parameters which are not in the
+// order follow in alphabetical order.
+func orderTheSection(params map[string]string, order []string) yaml.MapSlice {
+	section := yaml.MapSlice{}
+	added := make(map[string]bool)
+	for _, key := range order {
+		if val, exists := params[key]; exists && !added[key] {
+			section = append(section, yaml.MapItem{Key: key, Value: val})
+			added[key] = true
+		}
+	}
+	rest := []string{}
+	for key := range params {
+		if !added[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		section = append(section, yaml.MapItem{Key: key, Value: params[key]})
+	}
+	return section
+}
+
+// annotateTheDependencies adds a comment after each parameter in a section of the front matter
+// which is only used within optional clauses, naming the clauses which must be turned on for the
+// parameter to matter:
+//
+//	party3: ""  # only used when is_three_party is true
+func annotateTheDependencies(section string, params map[string]string, dependencies map[string][]string) string {
+
+	comments := make(map[string]string)
+	for key, val := range params {
+		if clauses, exists := dependencies[key]; exists {
+			lineAsByteArray, _ := yaml.Marshal(map[string]string{key: val})
+			comments[strings.SplitN(string(lineAsByteArray), "\n", 2)[0]] = describeTheDependency(clauses)
+		}
+	}
+	if len(comments) == 0 {
+		return section
+	}
+
+	lines := strings.Split(section, "\n")
+	for i, line := range lines {
+		if comment, exists := comments[line]; exists {
+			lines[i] = line + "  # " + comment
+		}
+	}
+	return strings.Join(lines, "\n")
+}
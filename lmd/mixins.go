@@ -6,13 +6,22 @@ import (
 	"strings"
 )
 
+// removedTextMarker is left in the contents by runThisOptionalClause and runTextMixins wherever
+// they take text out, so that cleanUpPostMixins knows which gaps to tidy.
+const removedTextMarker = "\x00removed\x00"
+
+// blankLineMarker stands in for a blank line which the author wrote until the document is written
+// out, so that it is not closed up along with the blank lines left by the parser.
+const blankLineMarker = "\x00blank\x00"
+
 // HandleMixins is the primary handling function for the mixin and optional clause parsing exercise
 // which is a subset of the overall parse job.
 //
-// The first thing which the function will do is to extract the parameters which we know are applicable
-// to the structured_headers portion of the overall parse job rather than the mixins portion of the
-// overall parse job. These parameters are parked into a new map during the remainder of the mixin
-// operation.
+// The first thing which the function will do is to hide any text which the template has escaped
+// (see hideTheEscapes), which is put back once the structured headers have been handled. Then it
+// will extract the parameters which we know are applicable to the structured_headers portion of
+// the overall parse job rather than the mixins portion of the overall parse job. These parameters
+// are parked into a new map during the remainder of the mixin operation.
 //
 // After the parameters we do not want to handle have been parked, the function then runs through the
 // optional clauses parse adding those optional clauses which have been turned on, and taking out of
@@ -22,11 +31,17 @@ import (
 // and replaces all of the keys established in the text of the template with the values established
 // in the parameters.
 //
-// Finally a simple cleanup function is called to compress extraneous white space and then the function
-// returns the parsed and corrected contents along with the parked parameters which are relevant to
-// the structured_headers phase of the overall parse.
+// Finally a simple cleanup function is called to tidy the gaps which the optional clauses and mixins
+// left behind and then the function returns the parsed and corrected contents along with the parked
+// parameters which are relevant to the structured_headers phase of the overall parse.
 func HandleMixins(contents string, parameters map[string]string) (string, map[string]string) {
 
+	// hide any escaped text from the parser until the structured headers have been handled
+	contents = hideTheEscapes(contents)
+
+	// the schema has been used to check the parameters and is not wanted any longer
+	parameters = removeTheSchema(parameters)
+
 	// create a parking_lot variable and park the parameters we know we don't want to mess with
 	// during this phase
 	var params_parking_lot map[string]string
@@ -47,7 +62,7 @@ func HandleMixins(contents string, parameters map[string]string) (string, map[st
 func prepareParamsParkingLot(parameters map[string]string) (map[string]string, map[string]string) {
 
 	// define the parameters we want to blacklist in a slice of strings
-	parameters_blacklist_strings := []string{"level-[0-9]", "no-reset", "no-indent", "level-style"}
+	parameters_blacklist_strings := []string{"level-[0-9]", "no-reset", "no-indent", "level-style", "heading-style"}
 
 	// compile those strings into a slice of regular expressions.
 	parameters_blacklist_regexs := []*regexp.Regexp{}
@@ -103,11 +118,14 @@ func runOptionalClauses(contents string, parameters map[string]string) (string,
 
 // runTextMixins is a very simple function. it loops through the remaining parameters (it is called
 // after the optional clauses have run) and replaces the keys with the values from the parameters
-// map which remains.
+// map which remains. A mixin whose value is empty leaves a removedTextMarker behind.
 func runTextMixins(contents string, parameters map[string]string) string {
 
 	for to_replace, replacer := range parameters {
 		mixin_pattern := regexp.MustCompile(fmt.Sprintf(`(\{\{%v\}\})`, to_replace))
+		if strings.TrimSpace(replacer) == "" {
+			replacer = removedTextMarker
+		}
 		if mixin_pattern.MatchString(contents) {
 			contents = mixin_pattern.ReplaceAllString(contents, replacer)
 		}
@@ -116,22 +134,122 @@ func runTextMixins(contents string, parameters map[string]string) string {
 	return contents
 }
 
-// cleanUpPostMixins is a simple function which compresses excessive whitespace.
+// cleanUpPostMixins tidies the gaps which are left in the contents where optional clauses have been
+// turned off and mixins have been filled in with nothing, which runThisOptionalClause and
+// runTextMixins mark with a removedTextMarker. The rest of the contents is left as it was written,
+// so that double spaces and runs of blank lines which the author meant are kept.
+//
+// Within a line of text the spaces around a gap are squeezed into one space, or taken away at the
+// beginning or the end of the line, keeping its indent and the two spaces at its end which mark a
+// hard line break. A line which is left empty is taken away along with the blank lines around it,
+// leaving a single blank line. Lines which are meant to be kept exactly as written (regions fenced
+// with `~~~`, lines indented as code and the rows of tables) only have the markers taken out. See
+// isAVerbatimLine.
+//
+// The writer closes up the blank lines which the later passes leave between the pieces of the
+// document (see closeUpNewLines), so every blank line after the first in a run which the author
+// wrote is swapped for a blankLineMarker which the writer turns back into a blank line afterwards.
+// Within a structured headers block the blank lines only separate the provisions and their
+// paragraphs (see splitTheBlock), so they are left as they are.
 func cleanUpPostMixins(contents string) string {
 
-	// when there are more than two new_lines, squeeze those into two.
-	too_many_lines := regexp.MustCompile(`\n\n+`)
-	if too_many_lines.MatchString(contents) {
-		contents = too_many_lines.ReplaceAllString(contents, "\n\n")
+	gap_pattern := regexp.MustCompile(`[ \t]*(?:` + regexp.QuoteMeta(removedTextMarker) + `[ \t]*)+`)
+	fence_pattern := regexp.MustCompile(`\A\s*~~~`)
+	leading_space := regexp.MustCompile(`\A[ \t]*`)
+
+	lines := []string{}
+	removed := []bool{}
+	fencedLines := []bool{}
+	blockLines := []bool{}
+	fenced, block := false, false
+	for _, line := range strings.Split(contents, "\n") {
+
+		if fence_pattern.MatchString(line) {
+			fenced = !fenced
+		} else if !fenced && blockFencePattern.MatchString(line) {
+			block = !block
+		}
+		fencedLines = append(fencedLines, fenced)
+		blockLines = append(blockLines, block)
+		if !strings.Contains(line, removedTextMarker) {
+			lines = append(lines, line)
+			removed = append(removed, false)
+			continue
+		}
+		if fenced || isAVerbatimLine(line) {
+			lines = append(lines, strings.Replace(line, removedTextMarker, "", -1))
+			removed = append(removed, false)
+			continue
+		}
+
+		// squeeze the spaces around each gap into one space, keeping the indent of the line and any
+		// hard line break at its end.
+		indent := leading_space.FindString(line)
+		text := gap_pattern.ReplaceAllStringFunc(line[len(indent):], func(gap string) string {
+			if strings.Trim(gap, " \t") == gap {
+				return ""
+			}
+			return " "
+		})
+		text = strings.Trim(text, " \t")
+		if text == "" {
+			lines = append(lines, "")
+			removed = append(removed, true)
+			continue
+		}
+		if strings.HasSuffix(line, "  ") {
+			text = text + "  "
+		}
+		lines = append(lines, indent+text)
+		removed = append(removed, false)
+	}
+
+	// take away the lines which were left empty, squeezing the run of blank lines each of them was
+	// in into one, and keep the other runs of blank lines for the writer.
+	tidied := []string{}
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" || fencedLines[i] {
+			tidied = append(tidied, lines[i])
+			continue
+		}
+		j, gap := i, false
+		for ; j < len(lines) && strings.TrimSpace(lines[j]) == "" && !fencedLines[j]; j++ {
+			gap = gap || removed[j]
+		}
+		if gap {
+			tidied = append(tidied, "")
+		} else if blockLines[i] {
+			tidied = append(tidied, lines[i:j]...)
+		} else {
+			tidied = append(tidied, lines[i])
+			for k := i + 1; k < j; k++ {
+				tidied = append(tidied, blankLineMarker)
+			}
+		}
+		i = j - 1
 	}
 
-	// when there are more than two spaces, squeeze those into one.
-	too_many_space := regexp.MustCompile(` {2,}`)
-	if too_many_space.MatchString(contents) {
-		contents = too_many_space.ReplaceAllString(contents, " ")
+	return strings.Join(tidied, "\n")
+}
+
+// outsideTheFences applies the tidy function to the parts of the contents which are not within
+// regions fenced with `~~~`, and leaves the fenced regions exactly as they were written.
+func outsideTheFences(contents string, tidy func(string) string) string {
+	fencedRegion := regexp.MustCompile(`(?sm)^[ \t]*~~~.*?^[ \t]*~~~[^\n]*`)
+	tidied := ""
+	last := 0
+	for _, region := range fencedRegion.FindAllStringIndex(contents, -1) {
+		tidied = tidied + tidy(contents[last:region[0]]) + contents[region[0]:region[1]]
+		last = region[1]
 	}
+	return tidied + tidy(contents[last:])
+}
 
-	return contents
+// isAVerbatimLine returns true if the line is one which whitespace matters to and which should
+// be kept exactly as it was written: a line indented by four spaces or a tab, which markdown
+// treats as code, or the row of a table, whose cells may be lined up with spaces.
+func isAVerbatimLine(line string) bool {
+	return strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t") || isATableRow(line)
 }
 
 // separateOptionalClauses checks the parameters map and pulls out those parameters with a
@@ -185,7 +303,7 @@ func runThisOptionalClause(contents string, parameters map[string]string, clause
 			} else if toAdd {
 				contents = strings.Replace(contents, sub[0], strings.TrimSpace(sub[1]), 1)
 			} else {
-				contents = strings.Replace(contents, sub[0], "", -1)
+				contents = strings.Replace(contents, sub[0], removedTextMarker, -1)
 			}
 		}
 
@@ -36,25 +36,6 @@ func ReadAFile(file_to_read string) string {
 	return contents
 }
 
-// importIncludedFiles handles importing files into the primary contents string. First it compiles a
-// regular expression which will search for the trigger string `@include PARTIAL`.
-//
-// If one or more match is found, the function will simply replace the `@include PARTIAL` line with the
-// read in string of the included partial. The complete string will be returned to the calling function.
-func importIncludedFiles(fileContents string) string {
-	importRegExp := regexp.MustCompile(`(?m)^@include (.*?)$`)
-
-	if importRegExp.MatchString(fileContents) {
-		importedFiles := importRegExp.FindAllStringSubmatch(fileContents, -1)
-		for _, importedFile := range importedFiles {
-			fileContents = strings.Replace(fileContents, importedFile[0], ReadAFile(importedFile[1]), -1)
-		}
-		return fileContents
-	} else {
-		return fileContents
-	}
-}
-
 // parseTemplateToFindParameters handles paramaters which are passed to the parser either separately from the
 // template file or as part of the template file. This function manages the process of stripping paramaters
 // out of a template file. The function first compiles a YAML Front Matter regular expression. Then if a
@@ -76,10 +57,25 @@ func parseTemplateToFindParameters(fileContents string) (string, string) {
 // unmarshallParameters unmarshalls paramaters either in yaml (TBD) or json into the paramaters map. This
 // function is responsible for unmarshalling the paramaters from yaml or json strings into (first a byte
 // array) and subsequently into the paramaters map which is returned to the calling function.
+//
+// Parameters whose values are themselves maps, such as the structured definition of a level, are
+// flattened into the paramaters map with their keys joined by a period, so that
+//
+//	level-1:
+//	  prefix: "Article "
+//
+// becomes a "level-1.prefix" parameter.
 func unmarshallParameters(parameters string) map[string]string {
 	parameter_bytes := []byte(parameters)
 	param := make(map[string]string)
 	yaml.Unmarshal(parameter_bytes, &param)
+	nested := make(map[string]interface{})
+	yaml.Unmarshal(parameter_bytes, &nested)
+	for key, val := range nested {
+		if _, isAMap := val.(map[interface{}]interface{}); isAMap {
+			flattenParameter(key, val, param)
+		}
+	}
 	for key, val := range param {
 		if strings.TrimSpace(val) == "@today" {
 			year, month, day := time.Now().Date()
@@ -94,6 +90,58 @@ func unmarshallParameters(parameters string) map[string]string {
 	return param
 }
 
+// flattenParameter adds a nested parameter value to the paramaters map under keys which are joined
+// with a period. Scalar values are formatted as strings, lists become their items separated by
+// commas (or by schemaListSeparator within the schema) and empty values become empty strings.
+func flattenParameter(key string, val interface{}, param map[string]string) {
+	switch val := val.(type) {
+	case map[interface{}]interface{}:
+		for subKey, subVal := range val {
+			flattenParameter(key+"."+fmt.Sprint(subKey), subVal, param)
+		}
+	case []interface{}:
+		items := []string{}
+		for _, item := range val {
+			items = append(items, fmt.Sprint(item))
+		}
+		separator := ", "
+		if strings.HasPrefix(key, schemaPrefix) {
+			separator = schemaListSeparator
+		}
+		param[key] = strings.Join(items, separator)
+	case nil:
+		param[key] = ""
+	default:
+		param[key] = fmt.Sprint(val)
+	}
+}
+
+// nestTheParameters is the opposite of flattenParameter. It gathers parameters whose keys were
+// joined with a period back into maps so that they are written out to front matter in the same
+// nested form in which they were read. Where a key has a value of its own as well as nested keys
+// (as when both "level-1" and "level-1.start" are set) the nested keys are left joined.
+func nestTheParameters(parameters map[string]string) map[string]interface{} {
+	nested := make(map[string]interface{})
+	for key, val := range parameters {
+		i := strings.Index(key, ".")
+		if i < 0 {
+			nested[key] = val
+			continue
+		}
+		if _, hasOwnValue := parameters[key[:i]]; hasOwnValue {
+			nested[key] = val
+			continue
+		}
+		group, _ := nested[key[:i]].(map[string]string)
+		if group == nil {
+			group = make(map[string]string)
+			nested[key[:i]] = group
+		}
+		group[key[i+1:]] = val
+	}
+	return nested
+}
+
 // mergeParameters is a convenience function which will merge two hash maps into one. Any conflicting parameters
 // in the two maps will be resolved in favor of the *first* map which is passed. That is to say that the first
 // map passed to the function, the `superior_map` map, will overwrite the `sublimated_map`.
@@ -0,0 +1,269 @@
+package lmd
+
+import (
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parameterSchema describes what a parameter of a template may hold. Kind is the type of its value
+// (string, number, integer, boolean or date), required is set when the parameter must be given a
+// value, allowed lists the only values it may take, pattern is a regular expression which the whole
+// of its value must match, description explains the parameter to whoever fills it in and def is
+// the value it takes when it is not given one.
+type parameterSchema struct {
+	name        string
+	kind        string
+	required    bool
+	allowed     []string
+	pattern     string
+	description string
+	def         string
+	hasDefault  bool
+}
+
+// schemaPrefix begins the keys of the parameters which hold the schema once the front matter has
+// been read, as in "schema.party.required".
+const schemaPrefix = "schema."
+
+// schemaListSeparator joins the items of the lists in the schema, such as the allowed values of a
+// parameter, once the front matter has been read. Unlike a comma it cannot appear within an item,
+// so that an allowed value such as "Smith, Jones LLP" is kept whole.
+const schemaListSeparator = "\x1f"
+
+// schemaKinds are the types which a parameter may be given in its schema.
+var schemaKinds = map[string]bool{
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"date":    true,
+}
+
+// schemaDateLayouts are the forms in which a date parameter may be written. The first two are the
+// forms which @today and @today_us give.
+var schemaDateLayouts = []string{"2 January 2006", "January 2, 2006", "2006-01-02", "2 Jan 2006", "Jan 2, 2006"}
+
+// schemaFilePatterns are the names of the files beside a template which may hold its schema, where
+// the template's own name (less its extension) takes the place of the %.
+var schemaFilePatterns = []string{"%.schema.yml", "%.schema.yaml", "%.schema.json"}
+
+// readTheSchema gathers the schema of a template. The schema may be written in the front matter
+// under a `schema` key, or in a file beside the template named for it (so that `lease.lmd` has its
+// schema in `lease.schema.yml`), or in both, in which case the fields in the front matter override
+// those in the file:
+//
+//	schema:
+//	  party:
+//	    type: string
+//	    required: true
+//	    description: The full legal name of the other party
+//	  term:
+//	    type: integer
+//	    default: 12
+//	  governing_law:
+//	    allowed: [England, Scotland]
+//
+// Fields which are not understood are reported as warnings. The contentsFile may be empty when
+// the template was not read from a file.
+func readTheSchema(parameters map[string]string, contentsFile string) map[string]*parameterSchema {
+
+	fields := make(map[string]string)
+	if contentsFile != "" && contentsFile != "-" {
+		base := strings.TrimSuffix(contentsFile, filepath.Ext(contentsFile))
+		for _, pattern := range schemaFilePatterns {
+			contents, err := ioutil.ReadFile(strings.Replace(pattern, "%", base, 1))
+			if err != nil {
+				continue
+			}
+			// the file is read as if it were the schema key of the front matter, so that its lists
+			// are flattened in the same way.
+			nested := make(map[interface{}]interface{})
+			yaml.Unmarshal(contents, &nested)
+			flattened := make(map[string]string)
+			flattenParameter(strings.TrimSuffix(schemaPrefix, "."), nested, flattened)
+			for key, val := range flattened {
+				fields[strings.TrimPrefix(key, schemaPrefix)] = val
+			}
+			break
+		}
+	}
+	for key, val := range parameters {
+		if strings.HasPrefix(key, schemaPrefix) {
+			fields[strings.TrimPrefix(key, schemaPrefix)] = val
+		}
+	}
+
+	schema := make(map[string]*parameterSchema)
+	for key, val := range fields {
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			addWarning(contentsFile, 0, "schema for %q does not describe any fields and has been ignored", key)
+			continue
+		}
+		name, field := key[:i], key[i+1:]
+		if schema[name] == nil {
+			schema[name] = &parameterSchema{name: name}
+		}
+		applyTheSchemaField(schema[name], field, val, contentsFile)
+	}
+	return schema
+}
+
+// applyTheSchemaField sets a single field of the schema of a parameter.
+func applyTheSchemaField(entry *parameterSchema, field string, val string, contentsFile string) {
+	switch field {
+	case "type":
+		entry.kind = strings.ToLower(strings.TrimSpace(val))
+		if !schemaKinds[entry.kind] {
+			addWarning(contentsFile, 0, "schema for %q has an unknown type %q which has been ignored", entry.name, val)
+			entry.kind = ""
+		}
+	case "required":
+		required, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			addWarning(contentsFile, 0, "schema for %q has a required field %q which is not true or false", entry.name, val)
+		}
+		entry.required = required
+	case "allowed":
+		entry.allowed = []string{}
+		for _, allowed := range strings.Split(val, schemaListSeparator) {
+			entry.allowed = append(entry.allowed, strings.TrimSpace(allowed))
+		}
+	case "regex":
+		if _, err := regexp.Compile(val); err != nil {
+			addWarning(contentsFile, 0, "schema for %q has a regex %q which does not compile and has been ignored", entry.name, val)
+			return
+		}
+		entry.pattern = val
+	case "description":
+		entry.description = val
+	case "default":
+		entry.def, entry.hasDefault = val, true
+	default:
+		addWarning(contentsFile, 0, "schema for %q has a field %q which is not understood and has been ignored", entry.name, field)
+	}
+}
+
+// validateTheParameters fills in the defaults of the schema for any parameters which have not been
+// given a value and then checks every parameter which the schema describes. Each problem is
+// recorded as an error diagnostic against the line on which the parameter was given its value (see
+// locateTheParameter), so that they are all reported together rather than one at a time, and in
+// strict mode the job is stopped before the template is parsed.
+func validateTheParameters(parameters map[string]string, schema map[string]*parameterSchema, contentsFile string, parametersFile string) map[string]string {
+
+	names := []string{}
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entry := schema[name]
+		if strings.TrimSpace(parameters[name]) == "" && entry.hasDefault {
+			parameters[name] = entry.def
+		}
+		for _, problem := range entry.check(parameters[name]) {
+			file, line := locateTheParameter(name, contentsFile, parametersFile)
+			addDiagnostic("error", file, line, "parameter %q %v", name, problem)
+		}
+	}
+	return parameters
+}
+
+// locateTheParameter returns the file and line on which a parameter is given its value: the
+// parameters file if it sets the parameter, or else the front matter of the template. A parameter
+// which is set in neither, such as a required parameter which is missing, or which was not read
+// from a file, is filed against the template with line 0.
+func locateTheParameter(name string, contentsFile string, parametersFile string) (string, int) {
+	for _, file := range []string{parametersFile, contentsFile} {
+		if file == "" || file == "-" {
+			continue
+		}
+		lines := strings.Split(ReadAFile(file), "\n")
+		if file == contentsFile && strings.TrimSpace(lines[0]) != "---" {
+			continue
+		}
+		for i, line := range lines {
+			if file == contentsFile && i > 0 && strings.TrimSpace(line) == "---" {
+				break
+			}
+			if match := frontMatterKeyPattern.FindStringSubmatch(line); match != nil && strings.Trim(match[1], `"'`) == name {
+				return file, i + 1
+			}
+		}
+	}
+	return contentsFile, 0
+}
+
+// check returns the ways in which the value of a parameter does not match its schema. A parameter
+// which has no value is only checked for whether it is required.
+func (entry *parameterSchema) check(val string) []string {
+
+	val = strings.TrimSpace(val)
+	if val == "" {
+		if entry.required {
+			return []string{"is required but has not been given a value"}
+		}
+		return nil
+	}
+
+	problems := []string{}
+	if problem := checkTheKind(entry.kind, val); problem != "" {
+		problems = append(problems, problem)
+	}
+	if len(entry.allowed) > 0 {
+		allowed := false
+		for _, option := range entry.allowed {
+			allowed = allowed || option == val
+		}
+		if !allowed {
+			problems = append(problems, "is \""+val+"\" but must be one of: "+strings.Join(entry.allowed, ", "))
+		}
+	}
+	if entry.pattern != "" && !regexp.MustCompile(`\A(?:`+entry.pattern+`)\z`).MatchString(val) {
+		problems = append(problems, "is \""+val+"\" which does not match "+entry.pattern)
+	}
+	return problems
+}
+
+// checkTheKind returns a problem if the value is not of the type given, or an empty string if it is.
+func checkTheKind(kind string, val string) string {
+	switch kind {
+	case "number":
+		if _, err := strconv.ParseFloat(val, 64); err != nil {
+			return "is \"" + val + "\" which is not a number"
+		}
+	case "integer":
+		if _, err := strconv.Atoi(val); err != nil {
+			return "is \"" + val + "\" which is not a whole number"
+		}
+	case "boolean":
+		if val != "true" && val != "false" {
+			return "is \"" + val + "\" which is not true or false"
+		}
+	case "date":
+		for _, layout := range schemaDateLayouts {
+			if _, err := time.Parse(layout, val); err == nil {
+				return ""
+			}
+		}
+		return "is \"" + val + "\" which is not a date"
+	}
+	return ""
+}
+
+// removeTheSchema takes the schema out of the parameters so that it is not mistaken for mixins.
+func removeTheSchema(parameters map[string]string) map[string]string {
+	for key := range parameters {
+		if strings.HasPrefix(key, schemaPrefix) {
+			delete(parameters, key)
+		}
+	}
+	return parameters
+}
@@ -0,0 +1,119 @@
+package lmd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tocEntry is a single provision as it will be listed in the table of contents. Level is the
+// level of the provision in the tree, leader is the final leader of the provision as it appears in
+// the document (less any markdown heading marks), heading is the heading of the provision, anchor
+// is the id which the entry links to and index is the position of the provision in the block.
+type tocEntry struct {
+	level   int
+	leader  string
+	heading string
+	anchor  string
+	index   int
+}
+
+// tocPattern matches the `@toc` lines which mark where a table of contents should be placed. The
+// number of levels of the tree which are listed may be set with `@toc levels=3`.
+var tocPattern = regexp.MustCompile(`(?m)^@toc(?:[ \t]+levels=(\d+))?[ \t]*$`)
+
+// tocDefaultLevels is the number of levels of the tree which are listed in a table of contents
+// when the `@toc` line does not say otherwise.
+const tocDefaultLevels = 2
+
+// tocStakePattern matches a cross reference stake which sits between the leader and the text of
+// a provision so that it can be left out of the heading.
+var tocStakePattern = regexp.MustCompile(`\A\s*\|.+?\|`)
+
+// wantsATableOfContents returns true if the contents contain an `@toc` line.
+func wantsATableOfContents(contents string) bool {
+	return tocPattern.MatchString(contents)
+}
+
+// makeATableOfContentsEntry builds the entry for a provision before its leader is replaced. The
+// anchors map holds the most recent anchor at each level of the tree. The anchor of a top level
+// provision is built from its leader, as in "article-2", and the anchor of any other provision adds
+// its own number to the anchor of its parent, as in "article-2-1-a", so that the anchors are unique
+// throughout the document.
+func makeATableOfContentsEntry(leader string, headers map[string]*Header, block string, oldStyle bool, anchors map[int]string) tocEntry {
+
+	level := headers[leader].levelNum
+	newLeader := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(assembleTheLeader(leader, headers, oldStyle)), "#"))
+	text := tocStakePattern.ReplaceAllString(block[len(leader):], "")
+
+	anchor := tocSlug(newLeader)
+	for parent := level - 1; parent > 0; parent-- {
+		if anchors[parent] != "" {
+			anchor = anchors[parent] + "-" + tocSlug(headers[leader].currtVal)
+			break
+		}
+	}
+	anchors[level] = anchor
+	for deeper := level + 1; anchors[deeper] != ""; deeper++ {
+		anchors[deeper] = ""
+	}
+
+	return tocEntry{level, newLeader, provisionHeading(text), anchor, 0}
+}
+
+// anchorTheProvision places an html anchor for the table of contents to link to directly after
+// the leader of the provision. Markdown renderers pass the anchor through untouched and it does not
+// show in the rendered text.
+func anchorTheProvision(block string, entry tocEntry) string {
+	anchor := "<a id=\"" + entry.anchor + "\"></a>"
+	if strings.Contains(block, entry.leader+" ") {
+		return strings.Replace(block, entry.leader+" ", entry.leader+" "+anchor, 1)
+	}
+	return strings.Replace(block, entry.leader, entry.leader+" "+anchor, 1)
+}
+
+// replaceTheTableOfContents replaces each `@toc` line in the contents with a table of contents
+// built from the entries. The table of contents is a nested markdown list of links, one for each
+// provision down to the number of levels requested, so that it becomes a list of links in the html
+// output. If there are no entries the `@toc` line is simply removed.
+func replaceTheTableOfContents(contents string, entries []tocEntry) string {
+	return tocPattern.ReplaceAllStringFunc(contents, func(line string) string {
+		levels := tocDefaultLevels
+		if option := tocPattern.FindStringSubmatch(line)[1]; option != "" {
+			levels, _ = strconv.Atoi(option)
+		}
+		return buildTheTableOfContents(entries, levels)
+	})
+}
+
+// buildTheTableOfContents assembles the markdown list for the entries down to the given number of
+// levels. The list is indented relative to the highest level of the tree which appears in it.
+func buildTheTableOfContents(entries []tocEntry, levels int) string {
+
+	top := 0
+	for _, entry := range entries {
+		if top == 0 || entry.level < top {
+			top = entry.level
+		}
+	}
+
+	lines := []string{}
+	for _, entry := range entries {
+		if entry.level-top >= levels {
+			continue
+		}
+		text := entry.leader
+		if entry.heading != "" {
+			text = text + " " + entry.heading
+		}
+		lines = append(lines, strings.Repeat("  ", entry.level-top)+"- ["+text+"](#"+entry.anchor+")")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tocSlug turns a leader into the form used in an anchor: lower case letters and numbers with a
+// single dash between each run of them.
+func tocSlug(text string) string {
+	nonWord := regexp.MustCompile(`[^a-z0-9]+`)
+	return strings.Trim(nonWord.ReplaceAllString(strings.ToLower(text), "-"), "-")
+}
@@ -1,7 +1,9 @@
 package lmd
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 )
 
 // next_lettering will iterate a given string to give the next logical lettering point
@@ -217,3 +219,115 @@ func from_arabic_to_roman_lower(arabic int) string {
 
 	return m6[arabic/1e6] + m5[arabic%1e6/1e5] + m4[arabic%1e5/1e4] + m3[arabic%1e4/1e3] + m2[arabic%1e3/1e2] + m1[arabic%100/10] + m0[arabic%10]
 }
+
+// next_padded_numbering increases zero padded numbers such as "01" while keeping the
+// width of the previous number, so "09" is followed by "10" and "099" by "100".
+func next_padded_numbering(previous string) string {
+	prev_as_digit, _ := strconv.Atoi(previous)
+	return fmt.Sprintf("%0*d", len(previous), prev_as_digit+1)
+}
+
+// prev_padded_numbering is the opposite of next_padded_numbering
+func prev_padded_numbering(previous string) string {
+	prev_as_digit, _ := strconv.Atoi(previous)
+	return fmt.Sprintf("%0*d", len(previous), prev_as_digit-1)
+}
+
+// is_doubled_lettering returns true if the string is made up of two or more of the same
+// english letter, as "aa" or "BBB". letters which are also roman numerals ("ii", "xx",
+// "cc", ...) are left to the roman numeral styles.
+func is_doubled_lettering(letters string) bool {
+	if len(letters) < 2 || strings.ContainsAny(letters[:1], "IVXLCDMivxlcdm") {
+		return false
+	}
+	return strings.Count(letters, letters[:1]) == len(letters)
+}
+
+// next_doubled_lettering iterates doubled letters in the way that statutes number their
+// subparagraphs: "aa" is followed by "bb" and so on to "zz", which is followed by "aaa".
+func next_doubled_lettering(previous string) string {
+	codepoint := previous[0]
+	if codepoint == 'z' || codepoint == 'Z' {
+		return strings.Repeat(string(codepoint-25), len(previous)+1)
+	}
+	return strings.Repeat(string(codepoint+1), len(previous))
+}
+
+// prev_doubled_lettering is the opposite of next_doubled_lettering.
+func prev_doubled_lettering(previous string) string {
+	codepoint := previous[0]
+	if codepoint == 'a' || codepoint == 'A' {
+		if len(previous) > 2 {
+			return strings.Repeat(string(codepoint+25), len(previous)-1)
+		}
+		return previous
+	}
+	return strings.Repeat(string(codepoint-1), len(previous))
+}
+
+// the words from which the spelled out ordinals are built.
+var (
+	ordinal_units = []string{"", "first", "second", "third", "fourth", "fifth", "sixth", "seventh", "eighth", "ninth",
+		"tenth", "eleventh", "twelfth", "thirteenth", "fourteenth", "fifteenth", "sixteenth", "seventeenth", "eighteenth", "nineteenth"}
+	ordinal_tens  = []string{"", "", "twentieth", "thirtieth", "fortieth", "fiftieth", "sixtieth", "seventieth", "eightieth", "ninetieth"}
+	cardinal_tens = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+)
+
+// from_arabic_to_ordinal is a convenience function which converts integers from 1 to 99
+// to lower cased spelled out ordinals, as 21 to "twenty-first". numbers outside of that
+// range are returned as digits.
+func from_arabic_to_ordinal(arabic int) string {
+	switch {
+	case arabic < 1 || arabic > 99:
+		return strconv.Itoa(arabic)
+	case arabic < 20:
+		return ordinal_units[arabic]
+	case arabic%10 == 0:
+		return ordinal_tens[arabic/10]
+	default:
+		return cardinal_tens[arabic/10] + "-" + ordinal_units[arabic%10]
+	}
+}
+
+// from_ordinal_to_arabic is a convenience function which converts spelled out ordinals,
+// in any case, to integers. it returns 0 if the word is not an ordinal.
+func from_ordinal_to_arabic(ordinal string) int {
+	ordinal = strings.ToLower(ordinal)
+	for arabic := 1; arabic <= 99; arabic++ {
+		if from_arabic_to_ordinal(arabic) == ordinal {
+			return arabic
+		}
+	}
+	return 0
+}
+
+// next_ordinal increases a spelled out ordinal by one position, keeping the case of the
+// previous ordinal so that "First" is followed by "Second" and "FIRST" by "SECOND".
+func next_ordinal(previous string) string {
+	return match_the_case(from_arabic_to_ordinal(from_ordinal_to_arabic(previous)+1), previous)
+}
+
+// prev_ordinal is the opposite of next_ordinal
+func prev_ordinal(previous string) string {
+	return match_the_case(from_arabic_to_ordinal(from_ordinal_to_arabic(previous)-1), previous)
+}
+
+// match_the_case gives a lower cased word the case of the model: upper case if the model
+// is all upper case, a capital at the start of each hyphenated part if the model begins
+// with a capital, or lower case otherwise.
+func match_the_case(word string, model string) string {
+	switch {
+	case model == strings.ToUpper(model):
+		return strings.ToUpper(word)
+	case model[:1] == strings.ToUpper(model[:1]):
+		parts := strings.Split(word, "-")
+		for i, part := range parts {
+			if part != "" {
+				parts[i] = strings.ToUpper(part[:1]) + part[1:]
+			}
+		}
+		return strings.Join(parts, "-")
+	default:
+		return word
+	}
+}
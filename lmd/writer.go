@@ -9,13 +9,17 @@ import (
 	"strings"
 )
 
+// blankLinePattern matches a line holding a blankLineMarker, along with any indent it was given
+// and the blank lines which the parser has left after it.
+var blankLinePattern = regexp.MustCompile(`(?m)^[ \t]*` + regexp.QuoteMeta(blankLineMarker) + `[ \t]*\n(?:[ \t]*\n)*`)
+
 // writeAFile is a convenience function for writing files. It also does the final cleanup
 // by cleaning extraneous new lines and after that parsing and inserting a signature block
 // if such is requested by the user.
 func writeAFile(file_to_write string, contents_to_write string) {
 
 	// close up extraneous new lines
-	contents_to_write = strings.Replace(contents_to_write, "\n\n\n", "\n\n", -1)
+	contents_to_write = closeUpNewLines(contents_to_write)
 
 	signatureBlock := regexp.MustCompile(`\@signature\((.*?):(.*?)\)`)
 	if signatureBlock.MatchString(contents_to_write) {
@@ -63,3 +67,14 @@ Date
 	}
 
 }
+
+// closeUpNewLines closes up extraneous new lines throughout the contents except within regions
+// fenced with `~~~`, which are kept exactly as they were written, and then puts back the blank
+// lines which cleanUpPostMixins kept aside as the author wrote them. The author's run of blank
+// lines is put back whole, so any blank lines which the parser added after it are dropped.
+func closeUpNewLines(contents string) string {
+	contents = outsideTheFences(contents, func(text string) string {
+		return strings.Replace(text, "\n\n\n", "\n\n", -1)
+	})
+	return blankLinePattern.ReplaceAllString(contents, "\n")
+}